@@ -0,0 +1,125 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// CompareAndSwap 当 key 当前的 value 等于 old 时写入 new，key 不存在时不写入，返回是否写入
+func (db *DB) CompareAndSwap(key, old, new []byte) (bool, error) {
+	return db.conditionalPut(key, func(value []byte, exists bool) ([]byte, bool, error) {
+		return new, exists && bytes.Equal(value, old), nil
+	})
+}
+
+// PutIfAbsent key 不存在时写入 value，返回是否写入
+func (db *DB) PutIfAbsent(key, value []byte) (bool, error) {
+	return db.conditionalPut(key, func(_ []byte, exists bool) ([]byte, bool, error) {
+		return value, !exists, nil
+	})
+}
+
+// Increment 将 key 的 value 作为十进制整数加上 delta，key 不存在时从 0 开始，返回相加之后的值
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	var result int64
+	_, err := db.conditionalPut(key, func(value []byte, exists bool) ([]byte, bool, error) {
+		n, err := incrementValue(value, exists, delta)
+		if err != nil {
+			return nil, false, err
+		}
+		result = n
+		return []byte(strconv.FormatInt(n, 10)), true, nil
+	})
+	return result, err
+}
+
+// 在互斥锁中读取 key 当前的 value，由 fn 决定是否写入以及写入的 value，保证读取和写入之间没有其他的写入
+func (db *DB) conditionalPut(key []byte, fn func(value []byte, exists bool) ([]byte, bool, error)) (bool, error) {
+	if len(key) == 0 {
+		return false, ErrKeyIsEmpty
+	}
+
+	var pos *data.LogRecordPos
+	var newValue []byte
+	err := db.commitWrite(func() error {
+		value, exists, err := db.currentValue(key)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if newValue, ok, err = fn(value, exists); err != nil || !ok {
+			return err
+		}
+
+		pos, err = db.writeLogRecord(&data.LogRecord{
+			Key:   logRecordKeyWithSeq(key, nonTransactionSeqNum),
+			Value: newValue,
+			Type:  data.LogRecordNormal,
+		})
+		if err != nil {
+			return err
+		}
+		db.stageWrite(key, pos)
+		return nil
+	}, func() error {
+		if pos == nil {
+			return nil
+		}
+		if oldPos := db.index.Put(key, pos); oldPos != nil {
+			db.reclaim(oldPos)
+		}
+		db.notifyWatchers(eventSeq(pos.Fid, pos.Offset+pos.Size), []Change{{Type: EventPut, Key: key, Value: newValue}})
+		return nil
+	})
+	return pos != nil && err == nil, err
+}
+
+// 获取 key 当前的位置，组提交中已经写入但还没有更新到内存索引中的数据优先，删除的 key 返回 nil
+// 在访问此方法前必须持有互斥锁
+func (db *DB) currentPos(key []byte) *data.LogRecordPos {
+	if pos, ok := db.stagedWrites[string(key)]; ok {
+		return pos
+	}
+	return db.index.Get(key)
+}
+
+// 获取 key 当前的 value，过期的 key 和不存在一样处理
+// 在访问此方法前必须持有互斥锁
+func (db *DB) currentValue(key []byte) ([]byte, bool, error) {
+	pos := db.currentPos(key)
+	if pos == nil || pos.IsExpired(time.Now().UnixNano()) {
+		return nil, false, nil
+	}
+	value, err := db.getValuesByPosition(pos)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// 记录组提交中已经写入的 key，pos 为 nil 表示删除，组内之后的写入据此判断 key 当前的状态
+// 在访问此方法前必须持有互斥锁
+func (db *DB) stageWrite(key []byte, pos *data.LogRecordPos) {
+	if db.stagedWrites != nil {
+		db.stagedWrites[string(key)] = pos
+	}
+}
+
+// 将 value 作为十进制整数加上 delta
+func incrementValue(value []byte, exists bool, delta int64) (int64, error) {
+	var n int64
+	if exists {
+		var err error
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+			return 0, ErrValueNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrIncrementOverflow
+	}
+	return n + delta, nil
+}
@@ -0,0 +1,192 @@
+package bitcask_go
+
+import (
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// key 不存在
+	ok, err := db.CompareAndSwap([]byte("key"), nil, []byte("v1"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.PutIfAbsent([]byte("key"), []byte("v1"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = db.PutIfAbsent([]byte("key"), []byte("v2"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	ok, err = db.CompareAndSwap([]byte("key"), []byte("v2"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	ok, err = db.CompareAndSwap([]byte("key"), []byte("v1"), []byte("v3"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	val, err := db.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+
+	// 删除之后可以再次写入
+	assert.Nil(t, db.Delete([]byte("key")))
+	ok, err = db.PutIfAbsent([]byte("key"), []byte("v4"))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, err = db.CompareAndSwap(nil, nil, nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+}
+
+func TestDB_Increment(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-increment")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	n, err := db.Increment([]byte("counter"), 5)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), n)
+	n, err = db.Increment([]byte("counter"), -7)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(-2), n)
+
+	assert.Nil(t, db.Put([]byte("text"), []byte("abc")))
+	_, err = db.Increment([]byte("text"), 1)
+	assert.Equal(t, ErrValueNotInteger, err)
+	assert.Nil(t, db.Put([]byte("max"), []byte(strconv.FormatInt(math.MaxInt64, 10))))
+	_, err = db.Increment([]byte("max"), 1)
+	assert.Equal(t, ErrIncrementOverflow, err)
+
+	// 并发的自增在组提交中也不会丢失
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, err := db.Increment([]byte("concurrent"), 1)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	val, err := db.Get([]byte("concurrent"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), val)
+}
+
+func TestWriteBatch_Preconditions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-preconditions")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("version"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("counter"), []byte("10")))
+
+	// 前置条件不满足时整个批次都不写入
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.CompareAndSwap([]byte("version"), []byte("2"), []byte("3")))
+	assert.Nil(t, wb.Put([]byte("data"), []byte("value")))
+	assert.Nil(t, wb.Increment([]byte("counter"), 1))
+	assert.Equal(t, ErrPreconditionFailed, wb.Commmit())
+	_, err = db.Get([]byte("data"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.PutIfAbsent([]byte("version"), []byte("1")))
+	assert.Equal(t, ErrPreconditionFailed, wb.Commmit())
+
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.CompareAndSwap([]byte("version"), []byte("1"), []byte("2")))
+	assert.Nil(t, wb.PutIfAbsent([]byte("data"), []byte("value")))
+	assert.Nil(t, wb.Increment([]byte("counter"), 1))
+	assert.Nil(t, wb.Increment([]byte("counter"), 2))
+	assert.Nil(t, wb.Increment([]byte("new counter"), -1))
+	assert.Nil(t, wb.Commmit())
+
+	for key, expected := range map[string]string{"version": "2", "data": "value", "counter": "13", "new counter": "-1"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(expected), val)
+	}
+
+	// value 不是整数时提交失败
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Increment([]byte("data"), 1))
+	assert.Equal(t, ErrValueNotInteger, wb.Commmit())
+}
+
+// 批次中对同一个 key 的多次写入按照顺序生效
+func TestWriteBatch_PendingWrites(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-batch-pending")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("deleted"), []byte("10")))
+	assert.Nil(t, db.Put([]byte("version"), []byte("1")))
+
+	// 自增基于批次中之前的 Put 和 Increment，之前删除过的 key 从 0 开始
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("put"), []byte("5")))
+	assert.Nil(t, wb.Increment([]byte("put"), 1))
+	assert.Nil(t, wb.Increment([]byte("put"), 2))
+	assert.Nil(t, wb.Delete([]byte("deleted")))
+	assert.Nil(t, wb.Increment([]byte("deleted"), 1))
+	assert.Nil(t, wb.CompareAndSwap([]byte("version"), []byte("1"), []byte("7")))
+	assert.Nil(t, wb.Increment([]byte("version"), 1))
+	assert.Nil(t, wb.Commmit())
+
+	for key, expected := range map[string]string{"put": "8", "deleted": "1", "version": "8"} {
+		val, err := db.Get([]byte(key))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte(expected), val)
+	}
+
+	// 之后的 Put 覆盖之前的自增
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Increment([]byte("put"), 1))
+	assert.Nil(t, wb.Put([]byte("put"), []byte("value")))
+	assert.Nil(t, wb.Commmit())
+	val, err := db.Get([]byte("put"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 之后的 Put 和 Delete 覆盖之前的前置条件
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.CompareAndSwap([]byte("version"), []byte("1"), []byte("2")))
+	assert.Nil(t, wb.Put([]byte("version"), []byte("3")))
+	assert.Nil(t, wb.PutIfAbsent([]byte("put"), []byte("new")))
+	assert.Nil(t, wb.Delete([]byte("put")))
+	assert.Nil(t, wb.Commmit())
+	val, err = db.Get([]byte("version"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+	_, err = db.Get([]byte("put"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
@@ -0,0 +1,113 @@
+package bitcask_go
+
+import (
+	"log"
+	"time"
+)
+
+// MergeWindow 一天中允许自动 merge 的时间段，用距离当天零点的时长表示
+// Start 大于 End 表示跨越零点，比如 22:00 到次日 06:00
+type MergeWindow struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// 判断 t 是否在时间段内，使用 t 所在的时区
+func (w MergeWindow) contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if w.Start <= w.End {
+		return offset >= w.Start && offset < w.End
+	}
+	return offset >= w.Start || offset < w.End
+}
+
+// 判断当前是否允许自动 merge，没有配置时间段表示任何时间都可以
+func inMergeWindows(windows []MergeWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// 是否启用了自动 merge
+func (options *Options) autoMergeEnabled() bool {
+	return options.AutoMergeInterval > 0 || options.AutoMergeWrites > 0
+}
+
+// 启动后台自动 merge 的协程
+func (db *DB) startAutoMerge() {
+	db.autoMergeTrigger = make(chan struct{}, 1)
+	db.autoMergeClose = make(chan struct{})
+	db.autoMergeDone = make(chan struct{})
+	go db.runAutoMerge()
+}
+
+// 停止后台自动 merge 的协程，会等待正在进行的 merge 完成
+func (db *DB) stopAutoMerge() {
+	if db.autoMergeClose == nil {
+		return
+	}
+	close(db.autoMergeClose)
+	<-db.autoMergeDone
+	db.autoMergeClose = nil
+}
+
+// 写入数据之后检查是否需要触发自动 merge
+// 在访问此方法前必须持有互斥锁
+func (db *DB) countWriteForAutoMerge() {
+	if db.options.AutoMergeWrites == 0 || db.autoMergeTrigger == nil {
+		return
+	}
+	db.writesSinceMerge++
+	if db.writesSinceMerge < db.options.AutoMergeWrites {
+		return
+	}
+	db.writesSinceMerge = 0
+	select {
+	case db.autoMergeTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (db *DB) runAutoMerge() {
+	defer close(db.autoMergeDone)
+
+	var tick <-chan time.Time
+	if db.options.AutoMergeInterval > 0 {
+		ticker := time.NewTicker(db.options.AutoMergeInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-db.autoMergeClose:
+			return
+		case <-tick:
+		case <-db.autoMergeTrigger:
+		}
+
+		if !inMergeWindows(db.options.AutoMergeWindows, time.Now()) || db.hasPendingMerge() {
+			continue
+		}
+
+		// 未达到阈值和已经在 merge 中时在下一次检查时重试，其他错误交给调用方处理
+		if err := db.Merge(); err != nil && err != ErrMergeRatioUnreached && err != ErrMergeIsProgress {
+			db.handleAutoMergeError(err)
+		}
+	}
+}
+
+func (db *DB) handleAutoMergeError(err error) {
+	if db.options.AutoMergeErrorHandler != nil {
+		db.options.AutoMergeErrorHandler(err)
+		return
+	}
+	log.Printf("bitcask: auto merge failed: %v", err)
+}
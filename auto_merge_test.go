@@ -0,0 +1,108 @@
+package bitcask_go
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestMergeWindow(t *testing.T) {
+	day := time.Date(2023, 6, 1, 0, 0, 0, 0, time.Local)
+	w1 := MergeWindow{Start: 2 * time.Hour, End: 4 * time.Hour}
+	assert.True(t, w1.contains(day.Add(3*time.Hour)))
+	assert.False(t, w1.contains(day.Add(4*time.Hour)))
+	assert.False(t, w1.contains(day.Add(23*time.Hour)))
+
+	// 跨越零点
+	w2 := MergeWindow{Start: 22 * time.Hour, End: 6 * time.Hour}
+	assert.True(t, w2.contains(day.Add(23*time.Hour)))
+	assert.True(t, w2.contains(day.Add(1*time.Hour)))
+	assert.False(t, w2.contains(day.Add(12*time.Hour)))
+
+	assert.True(t, inMergeWindows(nil, day))
+	assert.True(t, inMergeWindows([]MergeWindow{w1, w2}, day.Add(3*time.Hour)))
+	assert.False(t, inMergeWindows([]MergeWindow{w1, w2}, day.Add(12*time.Hour)))
+}
+
+func TestDB_AutoMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0.3
+	opts.AutoMergeWrites = 500
+	// 未达到阈值和已经在 merge 中不会交给回调
+	var mu sync.Mutex
+	var mergeErrs []error
+	opts.AutoMergeErrorHandler = func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		mergeErrs = append(mergeErrs, err)
+	}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1500; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 写入次数达到阈值之后在后台完成 merge
+	assert.Eventually(t, func() bool {
+		return db.Metrics().Merges > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 500, len(db.ListKeys()))
+	err = db.Close()
+	assert.Nil(t, err)
+	mu.Lock()
+	assert.Empty(t, mergeErrs)
+	mu.Unlock()
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 500, len(db2.ListKeys()))
+}
+
+func TestDB_AutoMerge_Window(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-auto-merge-window")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.AutoMergeInterval = 10 * time.Millisecond
+	// 只允许在当前时间之后的一个时间段内 merge
+	now := time.Now()
+	offset := now.Sub(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()))
+	opts.AutoMergeWindows = []MergeWindow{{
+		Start: (offset + 2*time.Hour) % (24 * time.Hour),
+		End:   (offset + 3*time.Hour) % (24 * time.Hour),
+	}}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, uint32(0), db.activeFile.FileId)
+
+	// Close 之后协程退出
+	err = db.Close()
+	assert.Nil(t, err)
+	_, ok := <-db.autoMergeDone
+	assert.False(t, ok)
+}
@@ -0,0 +1,374 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"go.etcd.io/bbolt"
+
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+)
+
+const backupManifestName = "backup-manifest"
+
+// 备份目录中的 manifest，记录备份包含的所有文件
+type backupManifest struct {
+	backupGeneration
+	Files []backupFile `json:"files"`
+}
+
+// 备份时 merge 和 MergeFiles 的进度，变化之后同名的封存文件可能已经被重写
+type backupGeneration struct {
+	NonMergeFileId uint32 `json:"non_merge_file_id"`
+	CompactNum     uint64 `json:"compact_num"`
+}
+
+// 备份中的一个文件
+type backupFile struct {
+	Name     string `json:"name"`
+	Fid      uint32 `json:"fid"`      // 数据文件、blob 文件和 hint 文件对应的 id
+	Size     uint64 `json:"size"`     // 备份的字节数
+	Checksum string `json:"checksum"` // sha256 校验和
+}
+
+// 需要备份的文件，只备份前 size 个字节
+type backupSource struct {
+	name   string
+	fid    uint32
+	size   uint64
+	sealed bool                 // 封存的文件内容不再变化，只会被 merge 和 MergeFiles 重写
+	open   func() io.ReadCloser // 从头读取文件的内容
+}
+
+// 从 io.ReaderAt 读取前 size 个字节的备份文件
+func newBackupSource(name string, fid uint32, size uint64, sealed bool, r io.ReaderAt) backupSource {
+	return backupSource{name: name, fid: fid, size: size, sealed: sealed, open: func() io.ReadCloser {
+		return io.NopCloser(io.NewSectionReader(r, 0, int64(size)))
+	}}
+}
+
+// BackupIncremental 增量备份数据库到 dir 目录
+// 和上次备份相比没有变化的文件不会重复拷贝，活跃文件只拷贝到备份开始时刻的 WriteOff，
+// 最后写入 manifest 记录每个文件的 id、大小和校验和，Restore 时据此校验
+func (db *DB) BackupIncremental(dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	lastManifest, err := readBackupManifest(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lastFiles := make(map[string]backupFile)
+	var lastGen backupGeneration
+	if lastManifest != nil {
+		for _, file := range lastManifest.Files {
+			lastFiles[file.Name] = file
+		}
+		lastGen = lastManifest.backupGeneration
+	}
+
+	sources, gen, closeSources, err := db.backupSources()
+	if err != nil {
+		return err
+	}
+	defer closeSources()
+
+	// 两次备份之间没有 merge 过，封存的文件和上次备份时一致，不需要重新计算校验和
+	manifest := &backupManifest{backupGeneration: gen}
+	for _, src := range sources {
+		file, err := backupOneFile(dir, src, lastFiles[src.name], gen == lastGen, db.ioLimiter)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, file)
+	}
+
+	if err := writeBackupManifest(dir, manifest); err != nil {
+		return err
+	}
+
+	// 删除上次备份中已经不存在的文件，比如 merge 之前的数据文件
+	current := make(map[string]bool, len(manifest.Files))
+	for _, file := range manifest.Files {
+		current[file.Name] = true
+	}
+	for name := range lastFiles {
+		if !current[name] {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore 校验 backupDir 中的备份，并恢复到 targetDir 目录，targetDir 必须不存在或者为空
+func Restore(backupDir, targetDir string) error {
+	manifest, err := readBackupManifest(backupDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ErrBackupManifestNotFound
+		}
+		return err
+	}
+
+	if entries, err := os.ReadDir(targetDir); err == nil && len(entries) > 0 {
+		return ErrRestoreDirNotEmpty
+	}
+
+	// 先校验所有的文件，避免恢复出一个不完整的目录
+	for _, file := range manifest.Files {
+		f, err := os.Open(filepath.Join(backupDir, file.Name))
+		if err != nil {
+			return ErrBackupCorrupted
+		}
+		size, checksum, err := checksumOf(f, file.Size)
+		_ = f.Close()
+		if err != nil || size != file.Size || checksum != file.Checksum {
+			return ErrBackupCorrupted
+		}
+	}
+
+	if err := os.MkdirAll(targetDir, os.ModePerm); err != nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		f, err := os.Open(filepath.Join(backupDir, file.Name))
+		if err != nil {
+			return err
+		}
+		_, err = copyToFile(filepath.Join(targetDir, file.Name), f, file.Size)
+		_ = f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 获取需要备份的文件，持有读锁保证活跃文件的 WriteOff 落在完整的记录上
+// 备份期间持有 fileView，merge 替换掉的文件在备份完成之前不会被关闭
+func (db *DB) backupSources() ([]backupSource, backupGeneration, func(), error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	gen := backupGeneration{NonMergeFileId: db.nonMergeFileId, CompactNum: db.compactNum}
+
+	// 拷贝之前持久化活跃文件，备份中的数据都已经落盘
+	if !db.options.ReadOnly {
+		if err := db.syncActiveFiles(); err != nil {
+			return nil, gen, nil, err
+		}
+	}
+
+	view := db.newFileView()
+	var sources []backupSource
+	var files []*os.File
+	var tx *bbolt.Tx
+	release := func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+		if tx != nil {
+			_ = tx.Rollback()
+		}
+		db.releaseFileView(view)
+	}
+
+	// 整个替换的文件，打开之后即使被替换也可以读取到旧的内容
+	addFile := func(name string, fid uint32, sealed bool) error {
+		f, err := os.Open(filepath.Join(db.options.DirPath, name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		files = append(files, f)
+
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		sources = append(sources, newBackupSource(name, fid, uint64(info.Size()), sealed, f))
+		return nil
+	}
+
+	// 旧的数据文件不会再写入，直接取文件大小，同时备份对应的 hint 文件
+	for _, dataFile := range view.files {
+		size := dataFile.WriteOff
+		if dataFile != db.activeFile {
+			fileSize, err := dataFile.IoManager.Size()
+			if err != nil {
+				release()
+				return nil, gen, nil, err
+			}
+			size = fileSize
+			if err := addFile(filepath.Base(data.GetHintFileName(db.options.DirPath, dataFile.FileId)), dataFile.FileId, true); err != nil {
+				release()
+				return nil, gen, nil, err
+			}
+		}
+		sources = append(sources, newBackupSource(filepath.Base(data.GetDataFileName(db.options.DirPath, dataFile.FileId)),
+			dataFile.FileId, size, dataFile != db.activeFile, dataFileReader{dataFile}))
+	}
+	for _, blobFile := range view.blobs {
+		sources = append(sources, newBackupSource(filepath.Base(data.GetBlobFileName(db.options.DirPath, blobFile.FileId)),
+			blobFile.FileId, blobFile.WriteOff, blobFile != db.activeBlobFile, dataFileReader{blobFile}))
+	}
+
+	for _, name := range []string{data.HintFileName, data.MergeFinishedFileName, data.CompactFinishedFileName,
+		data.SeqNumFileName, manifestFileName} {
+		if err := addFile(name, 0, false); err != nil {
+			release()
+			return nil, gen, nil, err
+		}
+	}
+
+	// B+ 树索引随着写入原地修改，通过只读事务拷贝出和数据文件一致的索引
+	if bpt, ok := db.index.(*index.BPlusTree); ok {
+		var err error
+		if tx, err = bpt.Begin(); err != nil {
+			release()
+			return nil, gen, nil, err
+		}
+		sources = append(sources, backupSource{name: index.BPTreeIndexFileName, size: uint64(tx.Size()), open: func() io.ReadCloser {
+			return newTxReader(tx)
+		}})
+	}
+	return sources, gen, release, nil
+}
+
+// 备份一个文件，如果和上次备份的内容一致则不再拷贝
+// trustSealed 为 true 时，名称、id 和大小都没有变化的封存文件直接沿用上次的校验和
+func backupOneFile(dir string, src backupSource, last backupFile, trustSealed bool, limiter *utils.RateLimiter) (backupFile, error) {
+	file := backupFile{Name: src.name, Fid: src.fid, Size: src.size}
+	if trustSealed && src.sealed && last.Name != "" && last.Fid == src.fid && last.Size == src.size {
+		if info, err := os.Stat(filepath.Join(dir, src.name)); err == nil && uint64(info.Size()) == src.size {
+			file.Checksum = last.Checksum
+			return file, nil
+		}
+	}
+	if last.Name != "" && last.Size == src.size {
+		r := src.open()
+		size, checksum, err := checksumOf(limiter.Reader(r), src.size)
+		_ = r.Close()
+		if err != nil {
+			return file, err
+		}
+		if info, err := os.Stat(filepath.Join(dir, src.name)); err == nil &&
+			uint64(info.Size()) == size && checksum == last.Checksum {
+			file.Checksum = checksum
+			return file, nil
+		}
+	}
+
+	// 先写到临时文件，拷贝完成之后再替换
+	tmpPath := filepath.Join(dir, src.name+".tmp")
+	r := src.open()
+	checksum, err := copyToFile(tmpPath, limiter.Reader(r), src.size)
+	_ = r.Close()
+	if err != nil {
+		return file, err
+	}
+	file.Checksum = checksum
+	return file, os.Rename(tmpPath, filepath.Join(dir, src.name))
+}
+
+// 拷贝 size 个字节到文件中，返回数据的校验和
+func copyToFile(path string, r io.Reader, size uint64) (string, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.CopyN(io.MultiWriter(f, hash), r, int64(size)); err != nil {
+		return "", err
+	}
+	if err := f.Sync(); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 计算最多 limit 个字节的校验和，返回实际读取的字节数
+func checksumOf(r io.Reader, limit uint64) (uint64, string, error) {
+	hash := sha256.New()
+	n, err := io.Copy(hash, io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return 0, "", err
+	}
+	return uint64(n), hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func readBackupManifest(dir string) (*backupManifest, error) {
+	buf, err := os.ReadFile(filepath.Join(dir, backupManifestName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &backupManifest{}
+	if err := json.Unmarshal(buf, manifest); err != nil {
+		return nil, ErrBackupCorrupted
+	}
+	return manifest, nil
+}
+
+// 先写临时文件再重命名，保证 manifest 要么是旧的，要么是完整的新的
+func writeBackupManifest(dir string, manifest *backupManifest) error {
+	buf, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(dir, backupManifestName+".tmp")
+	if _, err := copyToFile(tmpPath, bytes.NewReader(buf), uint64(len(buf))); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dir, backupManifestName))
+}
+
+// 将数据文件适配为 io.ReaderAt，通过已经打开的文件读取，文件被删除之后依然可以读取
+type dataFileReader struct {
+	dataFile *data.DataFile
+}
+
+func (r dataFileReader) ReadAt(b []byte, off int64) (int, error) {
+	n, err := r.dataFile.IoManager.Read(b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// 读取 bbolt 只读事务中的索引文件
+type txReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func newTxReader(tx *bbolt.Tx) *txReader {
+	pr, pw := io.Pipe()
+	r := &txReader{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		_, err := tx.WriteTo(pw)
+		_ = pw.CloseWithError(err)
+	}()
+	return r
+}
+
+// 等待写入结束，同一个事务不能同时被多次读取
+func (r *txReader) Close() error {
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
@@ -0,0 +1,240 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_BackupIncremental(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-incremental")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-incremental-dest")
+	defer os.RemoveAll(backupDir)
+	err = db.BackupIncremental(backupDir)
+	assert.Nil(t, err)
+	sealedFile := filepath.Join(backupDir, filepath.Base(data.GetDataFileName(dir, 0)))
+	info1, err := os.Stat(sealedFile)
+	assert.Nil(t, err)
+
+	// 第二次备份不再拷贝没有变化的旧文件
+	for i := 1000; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(128))
+		assert.Nil(t, err)
+	}
+	err = db.BackupIncremental(backupDir)
+	assert.Nil(t, err)
+	info2, err := os.Stat(sealedFile)
+	assert.Nil(t, err)
+	assert.True(t, os.SameFile(info1, info2))
+	assert.Equal(t, info1.ModTime(), info2.ModTime())
+
+	// 备份之后的写入不在备份中
+	err = db.Put([]byte("after-backup"), []byte("value"))
+	assert.Nil(t, err)
+
+	// 恢复之后可以打开
+	restoreDir := dir + "-restore"
+	defer os.RemoveAll(restoreDir)
+	err = Restore(backupDir, restoreDir)
+	assert.Nil(t, err)
+	err = Restore(backupDir, restoreDir)
+	assert.Equal(t, ErrRestoreDirNotEmpty, err)
+
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2000, len(db2.ListKeys()))
+	val1, err := db.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	val2, err := db2.Get(utils.GetTestKey(1999))
+	assert.Nil(t, err)
+	assert.Equal(t, val1, val2)
+	_, err = db2.Get([]byte("after-backup"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 备份文件损坏时拒绝恢复
+	f, err := os.OpenFile(sealedFile, os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("corrupted"), 100)
+	assert.Nil(t, err)
+	_ = f.Close()
+	err = Restore(backupDir, dir+"-restore-corrupted")
+	assert.Equal(t, ErrBackupCorrupted, err)
+	_, err = os.Stat(dir + "-restore-corrupted")
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 没有 merge 过时不再读取封存的文件，merge 之后重新计算校验和
+func TestDB_BackupIncremental_Generation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-generation")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-generation-dest")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.BackupIncremental(backupDir))
+
+	// 封存的文件远大于每秒限制的字节数，只读取活跃文件时很快完成
+	db.SetBackgroundIOBytesPerSec(1024 * 1024)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	start := time.Now()
+	assert.Nil(t, db.BackupIncremental(backupDir))
+	assert.True(t, time.Since(start) < 600*time.Millisecond)
+
+	// merge 之后的文件和上次备份的文件同名，需要重新校验
+	db.SetBackgroundIOBytesPerSec(0)
+	assert.Nil(t, db.Merge())
+	assert.Nil(t, db.BackupIncremental(backupDir))
+	manifest, err := readBackupManifest(backupDir)
+	assert.Nil(t, err)
+	assert.Equal(t, db.nonMergeFileId, manifest.NonMergeFileId)
+
+	restoreDir := dir + "-restore"
+	defer os.RemoveAll(restoreDir)
+	assert.Nil(t, Restore(backupDir, restoreDir))
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	for _, i := range []int{0, 9, 5000, 9999} {
+		val1, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		val2, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, val1, val2)
+	}
+	assert.Nil(t, db2.Close())
+}
+
+// 备份包含封存的数据文件对应的 hint 文件，恢复之后直接用来加载索引
+func TestDB_BackupIncremental_HintFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-backup-hint-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-backup-hint-files-dest")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.BackupIncremental(backupDir))
+
+	// 活跃文件的 hint 文件还没有封存，不需要备份
+	manifest, err := readBackupManifest(backupDir)
+	assert.Nil(t, err)
+	names := make(map[string]bool)
+	for _, file := range manifest.Files {
+		names[file.Name] = true
+	}
+	for fid := uint32(0); fid < db.activeFile.FileId; fid++ {
+		assert.True(t, names[filepath.Base(data.GetHintFileName(dir, fid))])
+	}
+	assert.False(t, names[filepath.Base(data.GetHintFileName(dir, db.activeFile.FileId))])
+
+	restoreDir := dir + "-restore"
+	defer os.RemoveAll(restoreDir)
+	assert.Nil(t, Restore(backupDir, restoreDir))
+	report, err := Verify(restoreDir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+
+	restoreOpts := opts
+	restoreOpts.DirPath = restoreDir
+	db2, err := Open(restoreOpts)
+	assert.Nil(t, err)
+	for i := 0; i < 3000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_BackgroundIOBytesPerSec(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-background-io")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BackgroundIOBytesPerSec = 256 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	dirSize, err := utils.DirSize(dir)
+	assert.Nil(t, err)
+	assert.True(t, dirSize > 128*1024)
+
+	// 备份按照限制的速度读取
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-background-io-backup")
+	defer os.RemoveAll(backupDir)
+	start := time.Now()
+	backupErr := make(chan error)
+	go func() {
+		backupErr <- db.Backup(backupDir)
+	}()
+
+	// 备份期间不阻塞写入
+	time.Sleep(50 * time.Millisecond)
+	putStart := time.Now()
+	assert.Nil(t, db.Put([]byte("during-backup"), []byte("value")))
+	assert.True(t, time.Since(putStart) < 200*time.Millisecond)
+	assert.Nil(t, <-backupErr)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// merge 读取旧文件和写入新文件都会限速
+	start = time.Now()
+	assert.Nil(t, db.Merge())
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// 运行时取消限制
+	db.SetBackgroundIOBytesPerSec(0)
+	incrementalDir, _ := os.MkdirTemp("", "bitcask-go-background-io-incremental")
+	defer os.RemoveAll(incrementalDir)
+	start = time.Now()
+	assert.Nil(t, db.BackupIncremental(incrementalDir))
+	assert.True(t, time.Since(start) < 400*time.Millisecond)
+}
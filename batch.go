@@ -1,7 +1,9 @@
 package bitcask_go
 
 import (
+	"bytes"
 	"encoding/binary"
+	"strconv"
 	"sync"
 	"sync/atomic"
 
@@ -20,8 +22,14 @@ type WriteBatch struct {
 	mu            *sync.Mutex
 	db            *DB
 	pendingWrites map[string]*data.LogRecord // 暂存用户写入的数据
+	conditions    map[string]batchCondition  // 提交时 key 需要满足的前置条件
+	increments    map[string]int64           // 提交时在 key 当前的值上累加
+	incrBases     map[string]*data.LogRecord // 自增之前批次中对 key 的 Put 或 Delete，没有时基于已提交的值
 }
 
+// 提交时根据 key 当前的 value 判断是否满足前置条件
+type batchCondition func(value []byte, exists bool) bool
+
 // NewWriteBatch 初始化 WriteBatch
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 	// 如果不是 b+ 树索引，存储事务序列号文件不存在，且不是第一次加载 db 就禁用 writebatch
@@ -33,6 +41,9 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 		mu:            new(sync.Mutex),
 		db:            db,
 		pendingWrites: make(map[string]*data.LogRecord),
+		conditions:    make(map[string]batchCondition),
+		increments:    make(map[string]int64),
+		incrBases:     make(map[string]*data.LogRecord),
 	}
 }
 
@@ -47,9 +58,62 @@ func (wb *WriteBatch) Put(key []byte, value []byte) error {
 	// 暂存
 	logRecord := &data.LogRecord{Key: key, Value: value}
 	wb.pendingWrites[string(key)] = logRecord
+	wb.clearPending(string(key))
+	return nil
+}
+
+// CompareAndSwap 提交时 key 当前的 value 必须等于 old，否则整个批次提交失败，满足条件时写入 new
+func (wb *WriteBatch) CompareAndSwap(key, old, new []byte) error {
+	return wb.putWithCondition(key, new, func(value []byte, exists bool) bool {
+		return exists && bytes.Equal(value, old)
+	})
+}
+
+// PutIfAbsent 提交时 key 必须不存在，否则整个批次提交失败
+func (wb *WriteBatch) PutIfAbsent(key, value []byte) error {
+	return wb.putWithCondition(key, value, func(_ []byte, exists bool) bool {
+		return !exists
+	})
+}
+
+// Increment 提交时将 key 的 value 作为十进制整数加上 delta，value 不是整数时整个批次提交失败
+func (wb *WriteBatch) Increment(key []byte, delta int64) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	// 批次中之前的 Put 或 Delete 作为自增的基准，之前的自增直接累加
+	if _, ok := wb.increments[string(key)]; !ok {
+		if record := wb.pendingWrites[string(key)]; record != nil {
+			wb.incrBases[string(key)] = record
+		}
+	}
+
+	// value 在提交时计算
+	wb.pendingWrites[string(key)] = &data.LogRecord{Key: key}
+	wb.increments[string(key)] += delta
+	return nil
+}
+
+func (wb *WriteBatch) putWithCondition(key, value []byte, condition batchCondition) error {
+	if err := wb.Put(key, value); err != nil {
+		return err
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	wb.conditions[string(key)] = condition
 	return nil
 }
 
+// 新的 Put 或 Delete 覆盖批次中之前对 key 的自增和前置条件
+func (wb *WriteBatch) clearPending(key string) {
+	delete(wb.conditions, key)
+	delete(wb.increments, key)
+	delete(wb.incrBases, key)
+}
+
 // Delete 删除数据
 func (wb *WriteBatch) Delete(key []byte) error {
 	if len(key) == 0 {
@@ -58,6 +122,8 @@ func (wb *WriteBatch) Delete(key []byte) error {
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
+	wb.clearPending(string(key))
+
 	// 数据不存在直接返回
 	logRecordPos := wb.db.index.Get(key)
 	if logRecordPos == nil {
@@ -75,6 +141,9 @@ func (wb *WriteBatch) Delete(key []byte) error {
 
 // Commmit 提交事务，将暂存的数据写到数据文件，并更新内存索引
 func (wb *WriteBatch) Commmit() error {
+	if wb.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	wb.mu.Lock()
 	defer wb.mu.Unlock()
 
@@ -86,66 +155,140 @@ func (wb *WriteBatch) Commmit() error {
 		return ErrExceedMaxBatchNum
 	}
 
-	// 加锁保证事务提交串行化
-	wb.db.mu.Lock()
-	defer wb.db.mu.Unlock()
+	// 前置条件在写入时的互斥锁中校验，保证校验和提交的原子性
+	if err := wb.db.commitPendingWrites(wb.options.SyncWrites || wb.db.options.SyncWrites, wb.resolvePendingWrites); err != nil {
+		return err
+	}
 
-	// 获取当前最新的事务序列号
-	seqNum := atomic.AddUint64(&wb.db.seqNum, 1)
+	// 清空暂存数据，便于下一次事务 commit
+	wb.pendingWrites = make(map[string]*data.LogRecord)
+	wb.conditions = make(map[string]batchCondition)
+	wb.increments = make(map[string]int64)
+	wb.incrBases = make(map[string]*data.LogRecord)
+	return nil
+}
 
-	// 开始写数据到数据文件中
-	// 全部写完之后再更新内存索引
-	positions := make(map[string]*data.LogRecordPos)
-	for _, record := range wb.pendingWrites {
-		logRecordPos, err := wb.db.appendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(record.Key, seqNum),
-			Value: record.Value,
-			Type:  record.Type,
-		})
+// 校验前置条件，并根据 key 当前的值计算自增之后的 value
+// 在访问此方法前必须持有 db.mu 互斥锁
+func (wb *WriteBatch) resolvePendingWrites() (map[string]*data.LogRecord, error) {
+	for key, condition := range wb.conditions {
+		value, exists, err := wb.db.currentValue([]byte(key))
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if !condition(value, exists) {
+			return nil, ErrPreconditionFailed
 		}
-		positions[string(record.Key)] = logRecordPos
 	}
-	/*
-	 * 写一条标识事务完成的数据，这里是因为可能存在有些无效事务（事务原子性破坏了）
-	 * 在读取的时候看这个事务数据是不是有效的
-	 */
-	finishedRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(txnFinKey, seqNum),
-		Type: data.LogRecordTxnFinished,
+	if len(wb.increments) == 0 {
+		return wb.pendingWrites, nil
 	}
 
-	if _, err := wb.db.appendLogRecord(finishedRecord); err != nil {
-		return err
+	pendingWrites := make(map[string]*data.LogRecord, len(wb.pendingWrites))
+	for key, record := range wb.pendingWrites {
+		if delta, ok := wb.increments[key]; ok {
+			value, exists, err := wb.baseValue(record.Key)
+			if err != nil {
+				return nil, err
+			}
+			n, err := incrementValue(value, exists, delta)
+			if err != nil {
+				return nil, err
+			}
+			record = &data.LogRecord{Key: record.Key, Value: []byte(strconv.FormatInt(n, 10))}
+		}
+		pendingWrites[key] = record
 	}
+	return pendingWrites, nil
+}
+
+// 自增的基准值，批次中之前删除过的 key 从 0 开始
+// 在访问此方法前必须持有 db.mu 互斥锁
+func (wb *WriteBatch) baseValue(key []byte) ([]byte, bool, error) {
+	if base := wb.incrBases[string(key)]; base != nil {
+		return base.Value, base.Type != data.LogRecordDeleted, nil
+	}
+	return wb.db.currentValue(key)
+}
 
-	// 根据配置决定是否持久化
-	if wb.options.SyncWrites && wb.db.activeFile != nil {
-		if err := wb.db.activeFile.Sync(); err != nil {
+// 将一组数据以事务的形式写到数据文件，和其他写入一起经过组提交，持久化之后再更新内存索引
+// resolve 在写入之前执行，此时持有互斥锁，可以看到组内之前写入的数据，返回需要写入的数据
+func (db *DB) commitPendingWrites(sync bool, resolve func() (map[string]*data.LogRecord, error)) error {
+	var records []*data.LogRecord
+	var positions []*data.LogRecordPos
+	var finishedPos *data.LogRecordPos
+	return db.commitWriteWithSync(sync, func() error {
+		pendingWrites, err := resolve()
+		if err != nil || len(pendingWrites) == 0 {
 			return err
 		}
-	}
 
-	// 更新内存索引
-	for _, record := range wb.pendingWrites {
-		pos := positions[string(record.Key)]
-		var oldPos *data.LogRecordPos
-		if record.Type == data.LogRecordNormal {
-			oldPos = wb.db.index.Put(record.Key, pos)
+		// 获取当前最新的事务序列号
+		seqNum := atomic.AddUint64(&db.seqNum, 1)
+
+		// 开始写数据到数据文件中
+		// 全部写完之后再按照写入的顺序更新内存索引，订阅者收到的变更也是这个顺序
+		for _, record := range pendingWrites {
+			logRecordPos, err := db.writeLogRecord(&data.LogRecord{
+				Key:    logRecordKeyWithSeq(record.Key, seqNum),
+				Value:  record.Value,
+				Type:   record.Type,
+				Expire: record.Expire,
+			})
+			if err != nil {
+				return err
+			}
+			records = append(records, record)
+			positions = append(positions, logRecordPos)
 		}
-		if record.Type == data.LogRecordDeleted {
-			oldPos, _ = wb.db.index.Delete(record.Key)
+		/*
+		 * 写一条标识事务完成的数据，这里是因为可能存在有些无效事务（事务原子性破坏了）
+		 * 在读取的时候看这个事务数据是不是有效的
+		 */
+		finishedRecord := &data.LogRecord{
+			Key:  logRecordKeyWithSeq(txnFinKey, seqNum),
+			Type: data.LogRecordTxnFinished,
+		}
+		if finishedPos, err = db.writeLogRecord(finishedRecord); err != nil {
+			return err
 		}
 
-		if oldPos != nil {
-			wb.db.reclaimSize += oldPos.Size
+		// 事务完整写入之后组内之后的写入才能看到
+		for i, record := range records {
+			if record.Type == data.LogRecordDeleted {
+				db.stageWrite(record.Key, nil)
+			} else {
+				db.stageWrite(record.Key, positions[i])
+			}
+		}
+		return nil
+	}, func() error {
+		if finishedPos == nil {
+			return nil
 		}
-	}
 
-	// 清空暂存数据，便于下一次事务 commit
-	wb.pendingWrites = make(map[string]*data.LogRecord)
-	return nil
+		// 更新内存索引
+		changes := make([]Change, 0, len(records))
+		for i, record := range records {
+			pos := positions[i]
+			var oldPos *data.LogRecordPos
+			if record.Type == data.LogRecordNormal {
+				oldPos = db.index.Put(record.Key, pos)
+				changes = append(changes, Change{Type: EventPut, Key: record.Key, Value: record.Value})
+			}
+			if record.Type == data.LogRecordDeleted {
+				oldPos, _ = db.index.Delete(record.Key)
+				db.reclaim(pos)
+				changes = append(changes, Change{Type: EventDelete, Key: record.Key})
+			}
+
+			if oldPos != nil {
+				db.reclaim(oldPos)
+			}
+		}
+		db.notifyWatchers(eventSeq(finishedPos.Fid, finishedPos.Offset+finishedPos.Size), changes)
+		return nil
+	})
 }
 
 // key + Seq Number 编码
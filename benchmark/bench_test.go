@@ -74,3 +74,30 @@ func Benchmark_Delete(b *testing.B) {
 		assert.Nil(b, err)
 	}
 }
+
+func Benchmark_MultiGet(b *testing.B) {
+	closer := openDB()
+	defer closer()
+
+	for i := 0; i < 10000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(1024))
+		assert.Nil(b, err)
+	}
+
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	keys := make([][]byte, 100)
+	b.ResetTimer()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		for j := range keys {
+			keys[j] = utils.GetTestKey(r.Intn(10000))
+		}
+		_, errs := db.MultiGet(keys)
+		for _, err := range errs {
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 将 value 写入活跃的 blob 文件，返回 blob 在文件中的位置
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendBlob(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	encRecord, size := db.codec.EncodeLogRecord(logRecord)
+
+	// 活跃 blob 文件不存在或者写满之后打开新的 blob 文件
+	if db.activeBlobFile == nil ||
+		(db.activeBlobFile.WriteOff > 0 && db.activeBlobFile.WriteOff+size > db.options.DataFileSize) {
+		if db.activeBlobFile != nil {
+			if err := db.activeBlobFile.Sync(); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := db.activeBlobFile.Write(encRecord); err != nil {
+		return nil, err
+	}
+	db.bytesWrite += uint(size)
+	atomic.AddUint64(&db.metrics.bytesWritten, size)
+
+	return &data.LogRecordPos{Fid: db.activeBlobFile.FileId, Offset: writeOff, Size: size}, nil
+}
+
+// 设置当前活跃的 blob 文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) setActiveBlobFile() error {
+	var initialFileId uint32 = 0
+	if db.activeBlobFile != nil {
+		initialFileId = db.activeBlobFile.FileId + 1
+	}
+
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	blobFile.Codec = db.codec
+	db.blobFiles[initialFileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// 从磁盘中加载 blob 文件，id 最大的是活跃 blob 文件
+func (db *DB) loadBlobFiles() error {
+	fileIds, err := db.readFileIds(data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, fid := range fileIds {
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), db.fileIOType())
+		if err != nil {
+			return err
+		}
+		blobFile.Codec = db.codec
+
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = uint64(size)
+
+		db.blobFiles[uint32(fid)] = blobFile
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+// 根据内存索引统计每个 blob 文件中的无效数据量
+// hint 文件中只有有效的数据，所以不能依赖加载索引时的统计
+func (db *DB) loadBlobGarbage() {
+	if len(db.blobFiles) == 0 {
+		return
+	}
+
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+
+	now := time.Now().UnixNano()
+	liveSize := make(map[uint32]uint64)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Blob != nil && !pos.IsExpired(now) {
+			liveSize[pos.Blob.Fid] += pos.Blob.Size
+		}
+	}
+
+	db.blobGarbage = make(map[uint32]uint64)
+	for fid, blobFile := range db.blobFiles {
+		if blobFile.WriteOff > liveSize[fid] {
+			db.blobGarbage[fid] = blobFile.WriteOff - liveSize[fid]
+		}
+	}
+}
+
+// 记录无效数据的大小，以及所在数据文件中的无效数据，指向 blob 文件的数据同时记录 blob 文件中的无效数据
+// 在访问此方法前必须持有互斥锁
+func (db *DB) reclaim(pos *data.LogRecordPos) {
+	db.reclaimSize += pos.Size
+	db.fileGarbage[pos.Fid] += pos.Size
+	if pos.Blob != nil {
+		db.blobGarbage[pos.Blob.Fid] += pos.Blob.Size
+	}
+}
+
+// blob 文件的总大小
+// 在访问此方法前必须持有互斥锁
+func (db *DB) blobFilesSize() uint64 {
+	var size uint64
+	for _, blobFile := range db.blobFiles {
+		size += blobFile.WriteOff
+	}
+	return size
+}
+
+// BlobGC 回收 blob 文件中的无效数据
+// 无效数据占比达到 DataFileMergeRatio 的 blob 文件会被重写，有效的 value 写入新的 blob 文件，
+// 并在数据文件中追加新的位置记录，旧的位置记录计入 reclaimSize，由 Merge 清理
+func (db *DB) BlobGC() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var fids []uint32
+	for fid, blobFile := range db.blobFiles {
+		// 活跃 blob 文件还在写入，不参与回收
+		if blobFile == db.activeBlobFile || blobFile.WriteOff == 0 {
+			continue
+		}
+		if float32(db.blobGarbage[fid])/float32(blobFile.WriteOff) >= db.options.DataFileMergeRatio {
+			fids = append(fids, fid)
+		}
+	}
+	sort.Slice(fids, func(i, j int) bool {
+		return fids[i] < fids[j]
+	})
+
+	for _, fid := range fids {
+		if err := db.rewriteBlobFile(db.blobFiles[fid]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 将 blob 文件中有效的 value 重新写入，然后删除这个文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rewriteBlobFile(blobFile *data.DataFile) error {
+	now := time.Now().UnixNano()
+	var offset uint64 = 0
+	for {
+		logRecord, size, err := blobFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		// 和内存中的索引比较，仍然指向这里的才是有效的数据
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		pos := db.index.Get(realKey)
+		if pos != nil && pos.Blob != nil &&
+			pos.Blob.Fid == blobFile.FileId &&
+			pos.Blob.Offset == offset &&
+			!pos.IsExpired(now) {
+
+			newPos, err := db.appendLogRecord(&data.LogRecord{
+				Key:    logRecordKeyWithSeq(realKey, nonTransactionSeqNum),
+				Value:  logRecord.Value,
+				Type:   data.LogRecordNormal,
+				Expire: pos.Expire,
+			})
+			if err != nil {
+				return err
+			}
+			if oldPos := db.index.Put(realKey, newPos); oldPos != nil {
+				db.reclaim(oldPos)
+			}
+		}
+		offset += size
+	}
+
+	// 新写入的数据持久化之后才能删除旧的文件
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+
+	delete(db.blobFiles, blobFile.FileId)
+	delete(db.blobGarbage, blobFile.FileId)
+	if err := os.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId)); err != nil {
+		return err
+	}
+
+	// 快照可能还在读取这个文件，已经打开的文件删除之后依然可以读取，等到没有读者时再关闭
+	db.retireFiles([]*data.DataFile{blobFile})
+	return nil
+}
@@ -0,0 +1,102 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024 * 1024
+	opts.BlobThreshold = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	bigValue := func(i int) []byte {
+		return bytes.Repeat(utils.GetTestKey(i), 300)
+	}
+
+	// 大 value 写到 blob 文件，小 value 依然写在数据文件中
+	for i := 0; i < 200; i++ {
+		err := db.Put(utils.GetTestKey(i), bigValue(i))
+		assert.Nil(t, err)
+	}
+	err = db.Put([]byte("small"), []byte("value"))
+	assert.Nil(t, err)
+	assert.Less(t, db.activeFile.WriteOff, uint64(200*100))
+	assert.True(t, db.Stat().BlobFileNum > 1)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue(1), val)
+	val, err = db.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 覆盖和删除之后 blob 文件中产生无效数据
+	for i := 0; i < 150; i++ {
+		if i%2 == 0 {
+			err = db.Delete(utils.GetTestKey(i))
+		} else {
+			err = db.Put(utils.GetTestKey(i), []byte("small value"))
+		}
+		assert.Nil(t, err)
+	}
+	stat := db.Stat()
+	assert.True(t, stat.BlobReclaimableSize > 0)
+	assert.True(t, stat.ReclaimableSize > 0)
+
+	// 重启之后依然可以读取，并且重新统计无效数据
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.BlobReclaimableSize, db2.Stat().BlobReclaimableSize)
+
+	// 快照在 gc 之后依然可以读取旧的文件
+	snap, err := db2.NewSnapshot()
+	assert.Nil(t, err)
+	err = db2.BlobGC()
+	assert.Nil(t, err)
+	assert.True(t, db2.Stat().BlobReclaimableSize < stat.BlobReclaimableSize)
+	val, err = snap.Get(utils.GetTestKey(199))
+	assert.Nil(t, err)
+	assert.Equal(t, bigValue(199), val)
+	_ = snap.Close()
+
+	blobFiles, _ := filepath.Glob(filepath.Join(dir, "*"+data.BlobFileNameSuffix))
+	assert.Equal(t, len(db2.blobFiles), len(blobFiles))
+
+	// merge 之后数据文件中的 blob 位置依然有效
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	for i := 0; i < 200; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		if i >= 150 {
+			assert.Nil(t, err)
+			assert.Equal(t, bigValue(i), val)
+		} else if i%2 == 0 {
+			assert.Equal(t, ErrKeyNotFound, err)
+		} else {
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("small value"), val)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	bitcask "bitcask-go"
+)
+
+func main() {
+	repair := flag.String("repair", "", "rebuild a clean directory with only the good records at this path")
+	keys := flag.String("keys", "", "comma separated hex encryption keys, the current key first")
+	indexType := flag.String("index", "btree", "index type of the repaired directory: btree, art or bptree")
+	compression := flag.String("compression", "none", "value compression of the repaired directory: none, flate or gzip")
+	blobThreshold := flag.Uint("blob-threshold", 0, "store values larger than this in blob files in the repaired directory, 0 disables")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-keys k1,k2] [-repair target [-index type] [-compression type] [-blob-threshold n]] dir\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	dir := flag.Arg(0)
+
+	var encryptionKeys [][]byte
+	if *keys != "" {
+		for _, k := range strings.Split(*keys, ",") {
+			key, err := hex.DecodeString(k)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid encryption key %q: %v\n", k, err)
+				os.Exit(2)
+			}
+			encryptionKeys = append(encryptionKeys, key)
+		}
+	}
+
+	var report *bitcask.VerifyReport
+	var err error
+	if *repair != "" {
+		options := bitcask.DefaultOptions
+		options.DirPath = *repair
+		options.BlobThreshold = *blobThreshold
+		if options.IndexType, err = parseIndexType(*indexType); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if options.Compression, err = parseCompression(*compression); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		if len(encryptionKeys) > 0 {
+			options.EncryptionKey, options.PreviousEncryptionKeys = encryptionKeys[0], encryptionKeys[1:]
+		}
+		report, err = bitcask.Repair(dir, options)
+	} else {
+		report, err = bitcask.Verify(dir, encryptionKeys...)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to verify %s: %v\n", dir, err)
+		os.Exit(2)
+	}
+
+	for _, problem := range report.Problems {
+		fmt.Println(problem)
+	}
+	fmt.Printf("checked %d files, %d records, %d keys, %d problems\n",
+		report.Files, report.Records, report.Keys, len(report.Problems))
+	if *repair != "" {
+		fmt.Printf("wrote %d keys to %s\n", report.Keys, *repair)
+		return
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
+
+func parseIndexType(name string) (bitcask.IndexerType, error) {
+	switch name {
+	case "btree":
+		return bitcask.BTree, nil
+	case "art":
+		return bitcask.ART, nil
+	case "bptree":
+		return bitcask.BPlusTree, nil
+	}
+	return 0, fmt.Errorf("invalid index type %q", name)
+}
+
+func parseCompression(name string) (bitcask.CompressionType, error) {
+	switch name {
+	case "none":
+		return bitcask.NoCompression, nil
+	case "flate":
+		return bitcask.FlateCompression, nil
+	case "gzip":
+		return bitcask.GzipCompression, nil
+	}
+	return 0, fmt.Errorf("invalid compression %q", name)
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnsupportedCompression = errors.New("unsupported compression type")
+	ErrInvalidEncryptionKey   = errors.New("invalid encryption key, log record cannot be decrypted")
+)
+
+// 加密后的数据格式
+// | keyId | nonce | 密文（key + value）| tag |
+// | 4 | 12 | 变长 | 16 |
+const encryptionKeyIdSize = 4
+
+type CompressionType = byte
+
+const (
+	// NoCompression 不压缩
+	NoCompression CompressionType = iota
+	// FlateCompression 使用 deflate 算法压缩
+	FlateCompression
+	// GzipCompression 使用 gzip 格式压缩
+	GzipCompression
+)
+
+// Codec LogRecord 的编码配置，nil 或者零值表示不做任何处理
+// 解码时需要的信息都记录在 header 的标志位中，所以旧格式的数据依然可以读取
+type Codec struct {
+	Compression CompressionType // value 的压缩算法
+
+	keyId   uint32                 // 当前加密使用的密钥 id
+	aead    cipher.AEAD            // 当前加密使用的密钥，nil 表示不加密
+	keyring map[uint32]cipher.AEAD // 所有可以用于解密的密钥
+}
+
+// NewCodec 创建 Codec，key 为空表示不加密
+// previousKeys 是之前使用过的密钥，只用于解密，便于轮换密钥
+func NewCodec(compression CompressionType, key []byte, previousKeys [][]byte) (*Codec, error) {
+	codec := &Codec{Compression: compression}
+	if len(key) == 0 {
+		if len(previousKeys) > 0 {
+			return nil, errors.New("previous encryption keys need a current encryption key")
+		}
+		return codec, nil
+	}
+
+	codec.keyring = make(map[uint32]cipher.AEAD, len(previousKeys)+1)
+	for _, k := range append([][]byte{key}, previousKeys...) {
+		block, err := aes.NewCipher(k)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		codec.keyring[encryptionKeyId(k)] = aead
+	}
+	codec.keyId = encryptionKeyId(key)
+	codec.aead = codec.keyring[codec.keyId]
+	return codec, nil
+}
+
+// EncodeLogRecord 按照配置对 LogRecord 进行编码，返回字节数组及长度
+// 只有压缩后变小的 value 才会以压缩的形式存储，压缩之后再加密
+func (c *Codec) EncodeLogRecord(logRecord *LogRecord) ([]byte, uint64) {
+	if c == nil {
+		return EncodeLogRecord(logRecord)
+	}
+
+	value, flags := logRecord.Value, byte(0)
+	if c.Compression != NoCompression && len(value) > 0 {
+		compressed, err := compress(c.Compression, value)
+		if err == nil && len(compressed) < len(value) {
+			value, flags = compressed, c.Compression<<logRecordCompressionShift
+		}
+	}
+
+	if c.aead == nil {
+		return encodeLogRecord(logRecord, value, flags)
+	}
+
+	// header 中的 keySize 依然是明文 key 的长度，valueSize 是剩余部分的长度
+	sealedSize := encryptionKeyIdSize + c.aead.NonceSize() + len(logRecord.Key) + len(value) + c.aead.Overhead()
+	header := encodeLogRecordHeader(logRecord, flags|logRecordFlagEncrypted, sealedSize-len(logRecord.Key))
+
+	// header 作为附加数据参与认证，防止类型和过期时间被篡改
+	sealed := make([]byte, encryptionKeyIdSize+c.aead.NonceSize(), sealedSize)
+	binary.LittleEndian.PutUint32(sealed, c.keyId)
+	if _, err := io.ReadFull(rand.Reader, sealed[encryptionKeyIdSize:]); err != nil {
+		panic(err)
+	}
+	plaintext := make([]byte, 0, len(logRecord.Key)+len(value))
+	plaintext = append(append(plaintext, logRecord.Key...), value...)
+	sealed = c.aead.Seal(sealed, sealed[encryptionKeyIdSize:], plaintext, header[4:])
+
+	return packLogRecord(header, sealed)
+}
+
+// 解密数据，返回 key 和 value 拼接在一起的明文
+func (c *Codec) decrypt(header []byte, sealed []byte) ([]byte, error) {
+	if c == nil || len(sealed) < encryptionKeyIdSize {
+		return nil, ErrInvalidEncryptionKey
+	}
+	aead, ok := c.keyring[binary.LittleEndian.Uint32(sealed)]
+	if !ok || len(sealed) < encryptionKeyIdSize+aead.NonceSize() {
+		return nil, ErrInvalidEncryptionKey
+	}
+
+	nonce := sealed[encryptionKeyIdSize : encryptionKeyIdSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[encryptionKeyIdSize+aead.NonceSize():], header)
+	if err != nil {
+		return nil, ErrInvalidEncryptionKey
+	}
+	return plaintext, nil
+}
+
+// 密钥 id 取密钥的 sha256 摘要的前 4 个字节，用于在解密时找到对应的密钥
+func encryptionKeyId(key []byte) uint32 {
+	sum := sha256.Sum256(key)
+	return binary.LittleEndian.Uint32(sum[:encryptionKeyIdSize])
+}
+
+// 使用指定的算法压缩数据
+func compress(typ CompressionType, value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch typ {
+	case FlateCompression:
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		w = fw
+	case GzipCompression:
+		w = gzip.NewWriter(&buf)
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 使用指定的算法解压数据
+func decompress(typ CompressionType, value []byte) ([]byte, error) {
+	var r io.ReadCloser
+	switch typ {
+	case NoCompression:
+		return value, nil
+	case FlateCompression:
+		r = flate.NewReader(bytes.NewReader(value))
+	case GzipCompression:
+		gr, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		r = gr
+	default:
+		return nil, ErrUnsupportedCompression
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
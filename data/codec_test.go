@@ -0,0 +1,128 @@
+package data
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/fio"
+)
+
+func TestCompress(t *testing.T) {
+	value := bytes.Repeat([]byte("bitcask-go"), 100)
+	for _, typ := range []CompressionType{FlateCompression, GzipCompression} {
+		res, err := compress(typ, value)
+		assert.Nil(t, err)
+		assert.Less(t, len(res), len(value))
+
+		origin, err := decompress(typ, res)
+		assert.Nil(t, err)
+		assert.Equal(t, value, origin)
+	}
+
+	_, err := compress(3, value)
+	assert.Equal(t, ErrUnsupportedCompression, err)
+}
+
+func TestCodec_EncodeLogRecord(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 444, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.Remove(GetDataFileName(os.TempDir(), 444))
+	}()
+
+	// 可以压缩的 value
+	rec1 := &LogRecord{
+		Key:   []byte("name"),
+		Value: bytes.Repeat([]byte("bitcask-go"), 100),
+	}
+	codec := &Codec{Compression: GzipCompression}
+	res1, size1 := codec.EncodeLogRecord(rec1)
+	assert.Less(t, size1, uint64(len(rec1.Value)))
+	err = dataFile.Write(res1)
+	assert.Nil(t, err)
+
+	// 压缩之后反而变大的 value 不压缩
+	rec2 := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("a"),
+	}
+	res2, size2 := codec.EncodeLogRecord(rec2)
+	plain, _ := EncodeLogRecord(rec2)
+	assert.Equal(t, plain, res2)
+	err = dataFile.Write(res2)
+	assert.Nil(t, err)
+
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec1)
+	assert.Equal(t, size1, readSize1)
+
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec2)
+	assert.Equal(t, size2, readSize2)
+
+	// nil 表示不压缩
+	var nilCodec *Codec
+	res3, _ := nilCodec.EncodeLogRecord(rec1)
+	plain3, _ := EncodeLogRecord(rec1)
+	assert.Equal(t, plain3, res3)
+}
+
+func TestCodec_Encryption(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 555, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer func() {
+		_ = os.Remove(GetDataFileName(os.TempDir(), 555))
+	}()
+
+	oldKey := bytes.Repeat([]byte("k"), 16)
+	newKey := bytes.Repeat([]byte("n"), 32)
+	oldCodec, err := NewCodec(GzipCompression, oldKey, nil)
+	assert.Nil(t, err)
+
+	rec1 := &LogRecord{
+		Key:    []byte("name"),
+		Value:  bytes.Repeat([]byte("bitcask-go"), 100),
+		Type:   LogRecordDeleted,
+		Expire: 1000,
+	}
+	res1, size1 := oldCodec.EncodeLogRecord(rec1)
+	assert.False(t, bytes.Contains(res1, rec1.Key))
+	err = dataFile.Write(res1)
+	assert.Nil(t, err)
+
+	// 轮换之后新旧密钥加密的数据都可以读取
+	newCodec, err := NewCodec(NoCompression, newKey, [][]byte{oldKey})
+	assert.Nil(t, err)
+	rec2 := &LogRecord{Key: []byte("name"), Value: []byte("bitcask-kv")}
+	res2, size2 := newCodec.EncodeLogRecord(rec2)
+	err = dataFile.Write(res2)
+	assert.Nil(t, err)
+
+	dataFile.Codec = newCodec
+	readRec1, readSize1, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec1, readRec1)
+	assert.Equal(t, size1, readSize1)
+	readRec2, readSize2, err := dataFile.ReadLogRecord(size1)
+	assert.Nil(t, err)
+	assert.Equal(t, rec2, readRec2)
+	assert.Equal(t, size2, readSize2)
+
+	// 缺少密钥或者密钥错误
+	dataFile.Codec = nil
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+	dataFile.Codec, err = NewCodec(NoCompression, newKey, nil)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(0)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+
+	// 密钥长度不合法
+	_, err = NewCodec(NoCompression, []byte("short"), nil)
+	assert.NotNil(t, err)
+}
@@ -15,10 +15,13 @@ var (
 )
 
 const (
-	DataFileNameSuffix    = ".data"
-	HintFileName          = "hint-index"
-	MergeFinishedFileName = "merge-finished"
-	SeqNumFileName        = "seq-num"
+	DataFileNameSuffix      = ".data"
+	BlobFileNameSuffix      = ".blob"
+	HintFileNameSuffix      = ".hint"
+	HintFileName            = "hint-index"
+	MergeFinishedFileName   = "merge-finished"
+	CompactFinishedFileName = "compact-finished"
+	SeqNumFileName          = "seq-num"
 )
 
 // DataFile 数据文件
@@ -26,6 +29,7 @@ type DataFile struct {
 	FileId    uint32        // 文件 id
 	WriteOff  uint64        // 文件写偏移
 	IoManager fio.IOManager // io 读写管理
+	Codec     *Codec        // 编解码配置，读取加密的数据时需要
 }
 
 // OpenDataFile 打开新的数据文件，需要初始化 FileId 和 WriteOff
@@ -34,16 +38,34 @@ func OpenDataFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFi
 	return newDataFile(fileName, fileId, ioType)
 }
 
+// OpenBlobFile 打开存储大 value 的 blob 文件
+func OpenBlobFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetBlobFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
+}
+
 // OpenHintFile 打开 Hint 索引文件
-func OpenHintFile(dirPath string) (*DataFile, error) {
+func OpenHintFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType)
+}
+
+// OpenDataHintFile 打开单个数据文件对应的 hint 文件
+func OpenDataHintFile(dirPath string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := GetHintFileName(dirPath, fileId)
+	return newDataFile(fileName, fileId, ioType)
 }
 
 // OpenMergeFinishedFile 打开标识 merge 完成的文件
-func OpenMergeFinishedFile(dirPath string) (*DataFile, error) {
+func OpenMergeFinishedFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
 	fileName := filepath.Join(dirPath, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, ioType)
+}
+
+// OpenCompactFinishedFile 打开标识 MergeFiles 完成的文件
+func OpenCompactFinishedFile(dirPath string, ioType fio.FileIOType) (*DataFile, error) {
+	fileName := filepath.Join(dirPath, CompactFinishedFileName)
+	return newDataFile(fileName, 0, ioType)
 }
 
 // OpenSeqNumFile 打开存储事务序列号的文件
@@ -56,6 +78,14 @@ func GetDataFileName(dirPath string, fileId uint32) string {
 	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+DataFileNameSuffix)
 }
 
+func GetBlobFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+BlobFileNameSuffix)
+}
+
+func GetHintFileName(dirPath string, fileId uint32) string {
+	return filepath.Join(dirPath, fmt.Sprintf("%09d", fileId)+HintFileNameSuffix)
+}
+
 func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
 	// 初始化 IOManager
 	ioManager, err := fio.NewIOManager(fileName, ioType)
@@ -103,8 +133,12 @@ func (df *DataFile) ReadLogRecord(offset uint64) (*LogRecord, uint64, error) {
 	}
 
 	var recordSize = headerSize + header.keySize + header.valueSize
+	// 记录超出了文件末尾，说明写入的过程中被中断了
+	if offset+uint64(headerSize)+uint64(header.keySize)+uint64(header.valueSize) > uint64(fileSize) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire}
 
 	// 开始读取用户实际存储的 key/value 数据
 	if header.keySize > 0 || header.valueSize > 0 {
@@ -117,9 +151,13 @@ func (df *DataFile) ReadLogRecord(offset uint64) (*LogRecord, uint64, error) {
 			panic(err)
 		}
 
-		// 解出 key 和 value
-		logRecord.Key = kvBuf[:header.keySize]
-		logRecord.Value = kvBuf[header.keySize:]
+		// 解出 key 和 value，加密的数据先整体放在 value 中
+		if header.encrypted {
+			logRecord.Value = kvBuf
+		} else {
+			logRecord.Key = kvBuf[:header.keySize]
+			logRecord.Value = kvBuf[header.keySize:]
+		}
 	}
 
 	// 校验数据的有效性
@@ -128,6 +166,25 @@ func (df *DataFile) ReadLogRecord(offset uint64) (*LogRecord, uint64, error) {
 		return nil, 0, ErrInvalidCRC
 	}
 
+	// 校验通过之后再解密，这样密钥错误和数据损坏可以区分开
+	if header.encrypted {
+		plaintext, err := df.Codec.decrypt(headerBuf[crc32.Size:headerSize], logRecord.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Key = plaintext[:header.keySize]
+		logRecord.Value = plaintext[header.keySize:]
+	}
+
+	// 解密之后再解压 value
+	if header.compression != NoCompression {
+		value, err := decompress(header.compression, logRecord.Value)
+		if err != nil {
+			return nil, 0, err
+		}
+		logRecord.Value = value
+	}
+
 	return logRecord, uint64(recordSize), nil
 }
 
@@ -146,7 +203,7 @@ func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
 		Key:   key,
 		Value: EncodeLogRecordPos(pos),
 	}
-	encRecord, _ := EncodeLogRecord(record)
+	encRecord, _ := df.Codec.EncodeLogRecord(record)
 	return df.Write(encRecord)
 }
 
@@ -1,6 +1,7 @@
 package data
 
 import (
+	"io"
 	"os"
 	"testing"
 
@@ -113,3 +114,21 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec3, readRec3)
 	assert.Equal(t, size3, readSize3)
 }
+
+func TestDataFile_ReadLogRecord_Torn(t *testing.T) {
+	dataFile, err := OpenDataFile(os.TempDir(), 444, fio.StandardFIO)
+	assert.Nil(t, err)
+	defer os.Remove(GetDataFileName(os.TempDir(), 444))
+	defer dataFile.Close()
+
+	rec, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask kv go")})
+	err = dataFile.Write(rec)
+	assert.Nil(t, err)
+
+	// 只写入了一半的 LogRecord
+	err = dataFile.Write(rec[:len(rec)/2])
+	assert.Nil(t, err)
+
+	_, _, err = dataFile.ReadLogRecord(size)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
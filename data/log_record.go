@@ -11,35 +11,62 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinished
+	// LogRecordBlobPointer value 存储在 blob 文件中，记录中的 value 是 blob 的位置
+	LogRecordBlobPointer
+	// LogRecordRangeDeleted 删除 [key, value) 区间中的所有 key，value 为空表示没有上界
+	LogRecordRangeDeleted
 )
 
-// |--crc--|--type--|--keysize--|--valuesize--|--key--|--val--|
-// | 4 | 1 | 5 | 5 | - | - |
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// |--crc--|--type--|--keysize--|--valuesize--|--expire--|--key--|--val--|
+// | 4 | 1 | 5 | 5 | 10 | - | - |
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + binary.MaxVarintLen64 + 5
+
+// type 字节的低 3 位存储 LogRecord 类型，高位存储标志位
+const (
+	logRecordTypeMask byte = 0x07
+	// 第 5、6 位标识 value 的压缩算法
+	logRecordCompressionShift      = 5
+	logRecordCompressionMask  byte = 0x03 << logRecordCompressionShift
+	// 标识 key 和 value 经过了加密
+	logRecordFlagEncrypted byte = 1 << 4
+	// 标识 header 中带有过期时间
+	logRecordFlagExpire byte = 1 << 7
+)
 
 /**
  * LogRecord 写入到数据文件的记录 之所以叫日志
  * 是因为数据文件中的数据是追加写入的，类似日志的格式
  */
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key    []byte
+	Value  []byte
+	Type   LogRecordType
+	Expire int64 // 过期时间（UnixNano），0 表示永不过期
 }
 
 // 头部信息
 type logRecordHeader struct {
-	crc        uint32        // crc 值
-	recordType LogRecordType // LogRecord 类型
-	keySize    uint32        // key 长度
-	valueSize  uint32        // value 长度
+	crc         uint32          // crc 值
+	recordType  LogRecordType   // LogRecord 类型
+	compression CompressionType // value 的压缩算法
+	encrypted   bool            // key 和 value 是否经过加密
+	keySize     uint32          // key 长度
+	valueSize   uint32          // value 长度
+	expire      int64           // 过期时间
 }
 
 // LogRecordPos 数据内存索引，描述数据在磁盘上的位置
 type LogRecordPos struct {
 	Fid    uint32
 	Offset uint64
-	Size   uint64 // 标识数据在磁盘上的大小
+	Size   uint64        // 标识数据在磁盘上的大小
+	Expire int64         // 过期时间（UnixNano），0 表示永不过期
+	Blob   *LogRecordPos // value 在 blob 文件中的位置，nil 表示 value 和 key 存储在一起
+}
+
+// IsExpired 判断数据在 now 时刻是否已经过期
+func (pos *LogRecordPos) IsExpired(now int64) bool {
+	return pos.Expire > 0 && pos.Expire <= now
 }
 
 // TransactionRecord 暂存事务相关的数据
@@ -49,47 +76,78 @@ type TransactionRecord struct {
 }
 
 // EncodeLogRecord 对 LogRecord 进行编码，返回字节数组及长度
-// | crc | type | keySize | valSize| key | val |
-// | 4 | 1 | 变长（最大5）| 变长（最大5）| 变长 | 变长 |
+// | crc | type | keySize | valSize| expire | key | val |
+// | 4 | 1 | 变长（最大5）| 变长（最大5）| 变长（最大10，可选）| 变长 | 变长 |
 func EncodeLogRecord(logRecord *LogRecord) ([]byte, uint64) {
+	return encodeLogRecord(logRecord, logRecord.Value, 0)
+}
+
+// 使用指定的 value 和标志位进行编码，value 可能是经过压缩的数据
+func encodeLogRecord(logRecord *LogRecord, value []byte, flags byte) ([]byte, uint64) {
+	header := encodeLogRecordHeader(logRecord, flags, len(value))
+	return packLogRecord(header, logRecord.Key, value)
+}
+
+// 编码 header，crc 部分留空，等数据部分确定之后再填充
+func encodeLogRecordHeader(logRecord *LogRecord, flags byte, valueSize int) []byte {
 	// 初始化一个 header
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	// 第五个字节开始写
-	header[4] = logRecord.Type
+	header[4] = logRecord.Type | flags
 	var index = 5
 
 	index += binary.PutUvarint(header[index:], uint64(len(logRecord.Key)))
-	index += binary.PutUvarint(header[index:], uint64(len(logRecord.Value)))
+	index += binary.PutUvarint(header[index:], uint64(valueSize))
+
+	// 只有设置了过期时间才写入，保持和旧格式兼容
+	if logRecord.Expire > 0 {
+		header[4] |= logRecordFlagExpire
+		index += binary.PutVarint(header[index:], logRecord.Expire)
+	}
+	return header[:index]
+}
 
-	var size = index + len(logRecord.Key) + len(logRecord.Value)
+// 拼接 header 和数据部分，并计算 crc
+func packLogRecord(header []byte, parts ...[]byte) ([]byte, uint64) {
+	var size = len(header)
+	for _, part := range parts {
+		size += len(part)
+	}
 	encBytes := make([]byte, size)
 
-	copy(encBytes[:index], header[:index])
-	copy(encBytes[index:], logRecord.Key)
-	copy(encBytes[index+len(logRecord.Key):], logRecord.Value)
+	index := copy(encBytes, header)
+	for _, part := range parts {
+		index += copy(encBytes[index:], part)
+	}
 
 	// crc 校验
 	crc := crc32.ChecksumIEEE(encBytes[4:])
 	binary.LittleEndian.PutUint32(encBytes[:4], crc)
 
-	// debug crc
-	// fmt.Printf("header length: %d, crc: %d\n", index, crc)
-
 	return encBytes, uint64(size)
 }
 
 /*
- | pos.Fid | pos.Offset| pos.Size |
- | 变长（4） | 变长（8） | 变长（8） |
+ | pos.Fid | pos.Offset| pos.Size | pos.Expire | blob.Fid | blob.Offset | blob.Size |
+ | 变长（4） | 变长（8） | 变长（8） | 变长（8，可选）| 变长（4，可选） | 变长（8，可选） | 变长（8，可选） |
 */
 // 对位置索引进行编码，LogRecordPos
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32+binary.MaxVarintLen64*2)
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*6)
 	var index = 0
 	index += binary.PutUvarint(buf[index:], uint64(pos.Fid))
 	index += binary.PutUvarint(buf[index:], pos.Offset)
 	index += binary.PutUvarint(buf[index:], pos.Size)
+	// 有 blob 位置时过期时间必须写入，否则无法区分
+	if pos.Expire > 0 || pos.Blob != nil {
+		index += binary.PutVarint(buf[index:], pos.Expire)
+	}
+	if pos.Blob != nil {
+		index += binary.PutUvarint(buf[index:], uint64(pos.Blob.Fid))
+		index += binary.PutUvarint(buf[index:], pos.Blob.Offset)
+		index += binary.PutUvarint(buf[index:], pos.Blob.Size)
+	}
 	return buf[:index]
 }
 
@@ -100,12 +158,24 @@ func DecodeLogRecordPos(buf []byte) *LogRecordPos {
 	index += n
 	offset, n := binary.Uvarint(buf[index:])
 	index += n
-	size, _ := binary.Uvarint(buf[index:])
-	return &LogRecordPos{
+	size, n := binary.Uvarint(buf[index:])
+	index += n
+	// 旧格式中没有过期时间
+	var expire int64
+	if index < len(buf) {
+		expire, n = binary.Varint(buf[index:])
+		index += n
+	}
+	pos := &LogRecordPos{
 		Fid:    uint32(fileId),
 		Offset: offset,
 		Size:   size,
+		Expire: expire,
+	}
+	if index < len(buf) {
+		pos.Blob = DecodeLogRecordPos(buf[index:])
 	}
+	return pos
 }
 
 // 对字节数组中的 Header 信息进行解码
@@ -115,8 +185,10 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, uint32) {
 		return nil, 0
 	}
 	header := &logRecordHeader{
-		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		crc:         binary.LittleEndian.Uint32(buf[:4]),
+		recordType:  buf[4] & logRecordTypeMask,
+		compression: (buf[4] & logRecordCompressionMask) >> logRecordCompressionShift,
+		encrypted:   buf[4]&logRecordFlagEncrypted != 0,
 	}
 
 	// 分别取出 key 和 value
@@ -129,6 +201,13 @@ func decodeLogRecordHeader(buf []byte) (*logRecordHeader, uint32) {
 	header.valueSize = uint32(valueSize)
 	index += n
 
+	// 取出过期时间
+	if buf[4]&logRecordFlagExpire != 0 {
+		expire, n := binary.Varint(buf[index:])
+		header.expire = expire
+		index += n
+	}
+
 	return header, uint32(index)
 }
 
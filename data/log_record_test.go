@@ -104,3 +104,38 @@ func TestGetLogRecordCRC(t *testing.T) {
 	crc3 := getLogRecordCRC(res3, headerBuf3[crc32.Size:])
 	assert.Equal(t, uint32(526765529), crc3)
 }
+
+func TestEncodeLogRecord_WithExpire(t *testing.T) {
+	rec := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcask-go"),
+		Type:   LogRecordNormal,
+		Expire: 1700000000000000000,
+	}
+	res, n := EncodeLogRecord(rec)
+	assert.NotNil(t, res)
+
+	h, size := decodeLogRecordHeader(res)
+	assert.NotNil(t, h)
+	assert.Equal(t, LogRecordNormal, h.recordType)
+	assert.Equal(t, rec.Expire, h.expire)
+	assert.Equal(t, n, uint64(size)+uint64(len(rec.Key)+len(rec.Value)))
+
+	crc := getLogRecordCRC(rec, res[crc32.Size:size])
+	assert.Equal(t, h.crc, crc)
+}
+
+func TestEncodeLogRecordPos(t *testing.T) {
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	assert.Equal(t, pos1, DecodeLogRecordPos(EncodeLogRecordPos(pos1)))
+
+	pos2 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Expire: 1700000000000000000}
+	assert.Equal(t, pos2, DecodeLogRecordPos(EncodeLogRecordPos(pos2)))
+
+	pos3 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20, Blob: &LogRecordPos{Fid: 2, Offset: 300, Size: 4096}}
+	assert.Equal(t, pos3, DecodeLogRecordPos(EncodeLogRecordPos(pos3)))
+
+	assert.False(t, pos1.IsExpired(pos2.Expire))
+	assert.True(t, pos2.IsExpired(pos2.Expire))
+	assert.False(t, pos2.IsExpired(pos2.Expire-1))
+}
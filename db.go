@@ -3,13 +3,16 @@ package bitcask_go
 import (
 	"errors"
 	"fmt"
-	"io"
+	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofrs/flock"
 
@@ -22,6 +25,7 @@ import (
 const (
 	seqNumKey    = "seq.num"
 	fileLockName = "flock"
+	swapLockName = "swap-lock"
 )
 
 // DB bitcask 存储引擎实例
@@ -32,13 +36,51 @@ type DB struct {
 	activeFile       *data.DataFile            // 当前活跃数据文件用于写入
 	olderFiles       map[uint32]*data.DataFile // 旧的数据文件，只能用于读
 	index            index.Indexer             // 内存索引
+	codec            *data.Codec               // LogRecord 编码配置
 	seqNum           uint64                    // 事务序列号，全局递增
 	isMerging        bool                      // 是否正在 merge，只允许有一个merge 操作
 	seqNumFileExists bool                      // 存储事务序列号的文件是否存在
 	isInitial        bool                      // 是否第一次初始化此数据目录
 	fileLock         *flock.Flock              // 文件锁保证多线程之间的互斥
+	swapLock         *flock.Flock              // 替换 merge 产生的文件时的排他锁，只读模式加载文件时持有共享锁
+	swapMu           *sync.Mutex               // 同一个进程中串行地获取 swapLock
 	bytesWrite       uint                      // 累计写了多少个字节
 	reclaimSize      uint64                    // 标识有多少数据是无效的
+	truncatedSize    uint64                    // 打开时截断的损坏数据量
+	nonMergeFileId   uint32                    // 加载索引时最近一次 merge 没有参与的文件 id
+	activeHint       *hintWriter               // 活跃数据文件对应的 hint 文件
+	activeBlobFile   *data.DataFile            // 当前活跃 blob 文件用于写入
+	blobFiles        map[uint32]*data.DataFile // 所有的 blob 文件，包括活跃 blob 文件
+	blobGarbage      map[uint32]uint64         // 每个 blob 文件中无效数据的大小
+	commitMu         *sync.Mutex               // 保护 commitQueue 和 committing
+	commitQueue      []*commitRequest          // 等待组提交的写入
+	committing       bool                      // 是否有 leader 正在提交
+	refMu            *sync.Mutex               // 保护 fileVersion、fileRefs 和 retiredFiles
+	fileVersion      uint64                    // 文件版本，每次替换文件之后递增
+	fileRefs         map[uint64]int            // 每个文件版本上还未释放的读者数量
+	retiredFiles     []retiredFile             // 已经被替换但可能还在被读者使用的文件
+	writesSinceMerge uint                      // 上次触发自动 merge 之后的写入次数
+	autoMergeTrigger chan struct{}             // 写入次数达到阈值时通知自动 merge
+	autoMergeClose   chan struct{}             // 关闭时通知自动 merge 协程退出
+	autoMergeDone    chan struct{}             // 自动 merge 协程已经退出
+	watchMu          *sync.Mutex               // 保护 watchers
+	watchers         map[*watcher]struct{}     // 订阅变更的订阅者
+	valueCache       *valueCache               // 读取 value 的缓存，为 nil 表示不启用
+	fileGarbage      map[uint32]uint64         // 每个数据文件中无效数据的大小
+	compactedFileId  uint32                    // 小于此 id 的数据文件可能被 MergeFiles 重写过
+	compactNum       uint64                    // MergeFiles 完成的次数，只读模式下据此判断是否需要重新加载
+	fileGeneration   uint64                    // 打开之后 Merge 和 MergeFiles 替换数据文件的次数，替换前后的位置索引不能比较
+	ioLimiter        *utils.RateLimiter        // 限制 merge 和备份的读写速度
+	metrics          *metrics                  // 运行期间的监控指标
+
+	// 被 MergeFiles 重写过的 merge 产生的文件，hint 索引文件中指向这些文件的位置已经失效
+	compactedMergeFiles map[uint32]bool
+
+	// 加载索引时还没有读到完成标识的事务数据，只读模式下 Refresh 时继续使用
+	pendingTxns map[uint64][]*data.TransactionRecord
+
+	// 组提交中已经写入但还没有更新到内存索引的 key，组内之后的写入据此判断 key 当前的状态
+	stagedWrites map[string]*data.LogRecordPos
 }
 
 // Stat 存储引擎统计信息
@@ -47,6 +89,24 @@ type Stat struct {
 	DataFileNum     uint   // 数据文件总量
 	ReclaimableSize uint64 // 可以进行 merge 回收的数据量
 	DiskSize        uint64 // 数据目录所占磁盘空间大小
+
+	BlobFileNum         uint   // blob 文件总量
+	BlobReclaimableSize uint64 // 可以通过 BlobGC 回收的数据量
+
+	TruncatedSize uint64 // 打开时从数据文件末尾截断的损坏数据量
+
+	ValueCacheHits   uint64 // value 缓存命中的次数
+	ValueCacheMisses uint64 // value 缓存未命中的次数
+
+	DataFiles []DataFileStat // 每个数据文件中有效和无效数据的大小，按照文件 id 排序
+}
+
+// DataFileStat 单个数据文件的统计信息
+type DataFileStat struct {
+	FileId   uint32
+	Size     uint64 // 文件大小
+	LiveSize uint64 // 有效数据的大小
+	DeadSize uint64 // 无效数据的大小，可以通过 MergeFiles 回收
 }
 
 // Open 打开 bitcast 存储引擎实例
@@ -58,23 +118,42 @@ func Open(options Options) (*DB, error) {
 
 	var isInitial bool
 
-	// 判断目录是否存在，不存在则创建
+	// 判断目录是否存在，不存在则创建，只读模式下目录必须存在
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err := os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// 判断当前数据目录是否正在使用
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	// 判断当前数据目录是否正在使用，只读模式不获取文件锁，不影响写入的进程打开同一个目录
+	// 只读模式只在加载文件期间持有 swap-lock 的共享锁，和写入的进程替换 merge 文件互斥
+	var fileLock *flock.Flock
+	if !options.ReadOnly {
+		fileLock = flock.New(filepath.Join(options.DirPath, fileLockName))
+		hold, err := fileLock.TryLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+	}
+	// 打开失败时释放文件锁，便于修正配置之后重新打开
+	var opened bool
+	defer func() {
+		if !opened && fileLock != nil {
+			_ = fileLock.Unlock()
+		}
+	}()
+
+	codec, err := data.NewCodec(options.Compression, options.EncryptionKey, options.PreviousEncryptionKeys)
 	if err != nil {
 		return nil, err
 	}
-	if !hold {
-		return nil, ErrDatabaseIsUsing
-	}
 
 	entries, err := os.ReadDir(options.DirPath)
 	if err != nil {
@@ -85,91 +164,179 @@ func Open(options Options) (*DB, error) {
 		isInitial = true
 	}
 
+	// 校验数据目录的格式版本和创建时的配置
+	if err := checkManifest(options, isInitial); err != nil {
+		return nil, err
+	}
+
 	// 初始化 DB 实例结构体
 	db := &DB{
 		options:    options,
 		mu:         new(sync.RWMutex),
 		olderFiles: make(map[uint32]*data.DataFile),
+		blobFiles:  make(map[uint32]*data.DataFile),
+		commitMu:   new(sync.Mutex),
+		refMu:      new(sync.Mutex),
+		fileRefs:   make(map[uint64]int),
+		watchMu:    new(sync.Mutex),
+		watchers:   make(map[*watcher]struct{}),
 		index:      index.NewIndexer(options.IndexType, options.DirPath, options.SyncWrites),
+		codec:      codec,
 		isInitial:  isInitial,
 		fileLock:   fileLock,
+		swapMu:     new(sync.Mutex),
+		ioLimiter:  utils.NewRateLimiter(options.BackgroundIOBytesPerSec),
+		metrics:    newMetrics(),
+	}
+	if options.ValueCacheSize > 0 {
+		db.valueCache = newValueCache(options.ValueCacheSize)
 	}
 
-	// 加载 merge 数据目录
-	if err := db.logMergeFiles(); err != nil {
+	// 只读模式下加载期间持有共享锁，写入的进程不会同时替换 merge 产生的文件
+	if options.ReadOnly {
+		if err := db.lockSwap(); err != nil {
+			return nil, err
+		}
+		defer db.unlockSwap()
+	}
+
+	if err := db.load(); err != nil {
 		return nil, err
 	}
 
+	// 只读模式下写入的进程可能已经完成了 merge，但还没有替换文件
+	if options.ReadOnly {
+		if changed, err := db.mergeChanged(); err != nil || changed {
+			db.closeFiles()
+			if err == nil {
+				err = ErrMergeNotApplied
+			}
+			return nil, err
+		}
+	}
+
+	// 启动后台自动 merge
+	if options.autoMergeEnabled() && !options.ReadOnly {
+		db.startAutoMerge()
+	}
+
+	opened = true
+	return db, nil
+}
+
+// 加载数据目录中的文件，并构建内存索引
+func (db *DB) load() error {
+	// 加载 merge 数据目录，只读模式下不能修改数据目录，由写入的进程完成替换
+	if !db.options.ReadOnly {
+		if err := db.applyPendingMerge(); err != nil {
+			return err
+		}
+	}
+
 	// 加载数据文件，读取 hint file
 	if err := db.loadDataFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// 加载 blob 文件
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+	db.blobGarbage = make(map[uint32]uint64)
+	db.fileGarbage = make(map[uint32]uint64)
+
+	// 读取 MergeFiles 重写过的文件
+	if err := db.loadCompactFinished(); err != nil {
+		return err
 	}
 
 	// B+ 索引
-	if options.IndexType == BPlusTree {
+	if db.options.IndexType == BPlusTree {
 		if err := db.loadSeqNum(); err != nil {
-			return nil, err
+			return err
 		}
+		// 索引已经在磁盘上，只需要找到活跃文件中最后一条有效的记录
 		if db.activeFile != nil {
-			size, err := db.activeFile.IoManager.Size()
-			if err != nil {
-				return nil, err
+			hints := scanDataFile(db.activeFile, 0)
+			if err := db.recoverDataFile(db.activeFile, hints); err != nil {
+				return err
 			}
-			db.activeFile.WriteOff = size
+			db.activeFile.WriteOff = hints.size
 		}
-
-		return db, nil
+		db.loadBlobGarbage()
+		return nil
 	}
 
 	// 从 hint 索引文件中加载索引
 	if err := db.loadIndexFromHintFile(); err != nil {
-		return nil, err
+		return err
 	}
 
 	// 从数据文件中加载索引
 	if err := db.loadIndexFromDataFiles(); err != nil {
-		return nil, err
+		return err
 	}
+	db.loadBlobGarbage()
 
 	// 重置 IO 类型，重置的原因是当前 mmap 的写和 sync 没有实现
-	if db.options.MMapAtStartup {
+	if db.options.MMapAtStartup && !db.options.ReadOnly {
 		if err := db.resetIoType(); err != nil {
-			return nil, err
+			return err
 		}
 	}
-
-	return db, nil
+	return nil
 }
 
 // 构造的 DB 的写操作，key 不能为空
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// PutWithTTL 写入数据并设置过期时间，ttl <= 0 表示永不过期
+// 过期的 key 对 Get、Fold、ListKeys 和迭代器不可见，并在 merge 时被清理
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	defer db.metrics.put.since(time.Now())
+
 	// key 是否有效
-	if len(value) == 0 {
+	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+
 	// 有效的 key-value 数据，构造 LogRecord 结构体
 	log_record := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, nonTransactionSeqNum),
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:    logRecordKeyWithSeq(key, nonTransactionSeqNum),
+		Value:  value,
+		Type:   data.LogRecordNormal,
+		Expire: expire,
 	}
 
 	// 对磁盘进行写，并返回索引
-	pos, err := db.appendLogRecordWithLock(log_record)
-	if err != nil {
-		return err
-	}
-
-	// 更新内存索引
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += oldPos.Size
-	}
-	return nil
+	var pos *data.LogRecordPos
+	return db.commitWrite(func() (err error) {
+		if pos, err = db.writeLogRecord(log_record); err != nil {
+			return err
+		}
+		db.stageWrite(key, pos)
+		return nil
+	}, func() error {
+		// 更新内存索引
+		if oldPos := db.index.Put(key, pos); oldPos != nil {
+			db.reclaim(oldPos)
+		}
+		db.notifyWatchers(eventSeq(pos.Fid, pos.Offset+pos.Size), []Change{{Type: EventPut, Key: key, Value: value}})
+		return nil
+	})
 }
 
 // Get 根据 key 读取数据
 func (db *DB) Get(key []byte) ([]byte, error) {
+	defer db.metrics.get.since(time.Now())
+
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -180,8 +347,8 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	// 从内存数据结构中取出 key 对应的索引信息
 	logRecordPos := db.index.Get(key)
 
-	// 如果 key 不在内存索引中，说明 key 不存在
-	if logRecordPos == nil {
+	// 如果 key 不在内存索引中或已经过期，说明 key 不存在
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
 		return nil, ErrKeyNotFound
 	}
 
@@ -190,48 +357,65 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 }
 
 func (db *DB) Delete(key []byte) error {
+	defer db.metrics.delete.since(time.Now())
+
 	// 空的 key
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
-	// 先检查 key 是否存在，不存在直接返回，不直接返回的情况下后续会导致日志出现很多无效的不存在 key 的记录
-	if pos := db.index.Get(key); pos == nil {
-		return nil
-	}
-
 	// 构造 LogRecord，标识其是被删除的
 	logRecord := &data.LogRecord{
 		Key:  logRecordKeyWithSeq(key, nonTransactionSeqNum),
 		Type: data.LogRecordDeleted,
 	}
 
-	// 写入到数据文件中
-	pos, err := db.appendLogRecordWithLock(logRecord)
-	if err != nil {
+	var pos *data.LogRecordPos
+	return db.commitWrite(func() (err error) {
+		// 先检查 key 是否存在，不存在直接返回，不直接返回的情况下后续会导致日志出现很多无效的不存在 key 的记录
+		if db.currentPos(key) == nil {
+			return nil
+		}
+
+		// 写入到数据文件中
+		if pos, err = db.writeLogRecord(logRecord); err != nil {
+			return err
+		}
+		db.stageWrite(key, nil)
 		return nil
-	}
-	db.reclaimSize += pos.Size
+	}, func() error {
+		if pos == nil {
+			return nil
+		}
+		db.reclaim(pos)
 
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
-		return ErrIndexUpdateFailed
-	}
-	
-	if oldPos != nil {
-		db.reclaimSize += oldPos.Size
-	}
+		oldPos, ok := db.index.Delete(key)
+		if !ok {
+			return ErrIndexUpdateFailed
+		}
 
-	return nil
+		if oldPos != nil {
+			db.reclaim(oldPos)
+		}
+		db.notifyWatchers(eventSeq(pos.Fid, pos.Offset+pos.Size), []Change{{Type: EventDelete, Key: key}})
+		return nil
+	})
 }
 
 func (db *DB) Close() error {
 	defer func() {
+		if db.fileLock == nil {
+			return
+		}
 		if err := db.fileLock.Unlock(); err != nil {
 			panic(fmt.Sprintf("failed to  unlock the directory, %v", err))
 		}
 	}()
 
+	// 先停止自动 merge，merge 需要使用数据文件
+	db.stopAutoMerge()
+	db.closeWatchers()
+
 	if db.activeFile == nil {
 		return nil
 	}
@@ -247,24 +431,17 @@ func (db *DB) Close() error {
 	if err := db.activeFile.Close(); err != nil {
 		return err
 	}
-
-	// 保存当前事务序列号
-	seqNumFile, err := data.OpenSeqNumFile(db.options.DirPath)
-	if err != nil {
-		return err
-	}
-
-	record := &data.LogRecord{
-		Key:   []byte(seqNumKey),
-		Value: []byte(strconv.FormatUint(db.seqNum, 10)),
+	if db.activeHint != nil {
+		if err := db.activeHint.close(); err != nil {
+			return err
+		}
 	}
 
-	encRecord, _ := data.EncodeLogRecord(record)
-	if err := seqNumFile.Write(encRecord); err != nil {
-		return err
-	}
-	if err := seqNumFile.Sync(); err != nil {
-		return err
+	// 保存当前事务序列号，只读模式下不修改数据目录
+	if !db.options.ReadOnly {
+		if err := db.saveSeqNum(); err != nil {
+			return err
+		}
 	}
 
 	// 关闭旧的数据文件
@@ -273,6 +450,26 @@ func (db *DB) Close() error {
 			return err
 		}
 	}
+
+	// 关闭 blob 文件
+	if db.activeBlobFile != nil && !db.options.ReadOnly {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, file := range db.blobFiles {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	for _, retired := range db.retiredFiles {
+		if err := retired.file.Close(); err != nil {
+			return err
+		}
+	}
+	db.retiredFiles = nil
 	return nil
 }
 
@@ -286,35 +483,121 @@ func (db *DB) Stat() *Stat {
 		dataFiles += 1
 	}
 
+	// 获取不到目录大小时磁盘占用记为 0
 	dirSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
-		panic(fmt.Sprintf("failed to get dir size: %v", err))
+		log.Printf("bitcask: failed to get dir size: %v", err)
+		dirSize = 0
+	}
+
+	var blobReclaimableSize uint64
+	for _, size := range db.blobGarbage {
+		blobReclaimableSize += size
+	}
+
+	// 获取不到文件大小时不影响其他统计信息，只是不输出每个文件的统计
+	fileStats, err := db.dataFileStats()
+	if err != nil {
+		log.Printf("bitcask: failed to get data file stats: %v", err)
 	}
 
+	cacheHits, cacheMisses := db.valueCache.stat()
 	return &Stat{
-		KeyNum:          uint(db.index.Size()),
-		DataFileNum:     dataFiles,
-		ReclaimableSize: db.reclaimSize,
-		DiskSize:        dirSize, // TODO
+		KeyNum:              uint(db.index.Size()),
+		DataFileNum:         dataFiles,
+		ReclaimableSize:     db.reclaimSize,
+		DiskSize:            dirSize, // TODO
+		BlobFileNum:         uint(len(db.blobFiles)),
+		BlobReclaimableSize: blobReclaimableSize,
+		TruncatedSize:       db.truncatedSize,
+		ValueCacheHits:      cacheHits,
+		ValueCacheMisses:    cacheMisses,
+		DataFiles:           fileStats,
 	}
 }
 
+// 每个数据文件的大小和其中无效数据的大小
+// 在访问此方法前必须持有互斥锁（读锁即可）
+func (db *DB) dataFileStats() ([]DataFileStat, error) {
+	var stats []DataFileStat
+	addFile := func(dataFile *data.DataFile, size uint64) {
+		dead := db.fileGarbage[dataFile.FileId]
+		if dead > size {
+			dead = size
+		}
+		stats = append(stats, DataFileStat{FileId: dataFile.FileId, Size: size, LiveSize: size - dead, DeadSize: dead})
+	}
+	for _, dataFile := range db.olderFiles {
+		size, err := dataFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		addFile(dataFile, uint64(size))
+	}
+	if db.activeFile != nil {
+		addFile(db.activeFile, db.activeFile.WriteOff)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].FileId < stats[j].FileId
+	})
+	return stats, nil
+}
+
 // Backup 备份数据库，将数据库拷贝到新的目录中，旨在数据恢复
+// 只在获取需要备份的文件时持有读锁，拷贝期间不阻塞写入
 func (db *DB) Backup(dir string) error {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+
+	sources, _, closeSources, err := db.backupSources()
+	if err != nil {
+		return err
+	}
+	defer closeSources()
+
+	for _, src := range sources {
+		r := src.open()
+		_, err := copyToFile(filepath.Join(dir, src.name), db.ioLimiter.Reader(r), src.size)
+		_ = r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetBackgroundIOBytesPerSec 修改 merge 和备份每秒最多读写的字节数，0 表示不限速，正在进行的 merge 和备份立即生效
+func (db *DB) SetBackgroundIOBytesPerSec(bytesPerSec uint64) {
+	db.ioLimiter.SetRate(bytesPerSec)
 }
 
 // 持久化数据文件
 func (db *DB) Sync() error {
-	if db.activeFile == nil {
+	// 只读模式下没有需要持久化的数据
+	if db.activeFile == nil || db.options.ReadOnly {
 		return nil
 	}
 
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	return db.syncActiveFiles()
+}
+
+// 持久化活跃数据文件和活跃 blob 文件，blob 文件需要先于指向它的数据持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncActiveFiles() error {
+	defer db.metrics.fsync.since(time.Now())
+
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile == nil {
+		return nil
+	}
 	return db.activeFile.Sync()
 }
 
@@ -323,11 +606,14 @@ func (db *DB) ListKeys() [][]byte {
 	iterator := db.index.Iterator(false)
 	defer iterator.Close()
 
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	now := time.Now().UnixNano()
+	keys := make([][]byte, 0, db.index.Size())
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
-		keys[idx] = iterator.Key()
-		idx++
+		// 跳过已经过期的 key
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
+		keys = append(keys, iterator.Key())
 	}
 
 	return keys
@@ -341,7 +627,11 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	iterator := db.index.Iterator((false))
 	defer iterator.Close()
 
+	now := time.Now().UnixNano()
 	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(now) {
+			continue
+		}
 		value, err := db.getValuesByPosition(iterator.Value())
 		if err != nil {
 			return err
@@ -356,6 +646,11 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 
 // 根据数据文件索引获取对应的 value
 func (db *DB) getValuesByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	// value 存储在 blob 文件中
+	if logRecordPos.Blob != nil {
+		return db.valueCache.read(db.blobFiles[logRecordPos.Blob.Fid], logRecordPos.Blob)
+	}
+
 	// 根据文件 id 找到对应的数据文件
 	var dataFile *data.DataFile
 	if db.activeFile.FileId == logRecordPos.Fid {
@@ -364,6 +659,11 @@ func (db *DB) getValuesByPosition(logRecordPos *data.LogRecordPos) ([]byte, erro
 		dataFile = db.olderFiles[logRecordPos.Fid]
 	}
 
+	return db.valueCache.read(dataFile, logRecordPos)
+}
+
+// 从指定的数据文件中读取 value
+func readValueFromDataFile(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
 	// 数据文件为空
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -382,14 +682,41 @@ func (db *DB) getValuesByPosition(logRecordPos *data.LogRecordPos) ([]byte, erro
 	return logRecord.Value, nil
 }
 
-func (db *DB) appendLogRecordWithLock(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
-	db.mu.Lock()
-	defer db.mu.Unlock()
-	return db.appendLogRecord(logRecord)
+// 向活跃文件写数据，并根据用户配置决定是否持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+	pos, err := db.writeLogRecord(logRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	// 根据用户配置决定是否持久化，每 BytesPerSync 个字节持久化一次
+	var needSync = db.options.SyncWrites
+	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
+		needSync = true
+	}
+
+	if needSync {
+		if err := db.syncWrites(); err != nil {
+			return nil, err
+		}
+	}
+	return pos, nil
 }
 
-// 向活跃文件写数据
-func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
+// 持久化已经写入的数据，并重置累计写入的字节数
+// 在访问此方法前必须持有互斥锁
+func (db *DB) syncWrites() error {
+	if err := db.syncActiveFiles(); err != nil {
+		return err
+	}
+	db.bytesWrite = 0
+	return nil
+}
+
+// 向活跃文件写数据，不进行持久化
+// 在访问此方法前必须持有互斥锁
+func (db *DB) writeLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, error) {
 
 	// 判断当前活跃文件是否存在，不存在则初始化数据文件，数据库没有写入的时候没有文件生成
 	if db.activeFile == nil {
@@ -398,19 +725,26 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		}
 	}
 
-	// 写入数据编码
-	encRecord, size := data.EncodeLogRecord(logRecord)
-	// 如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新文件写
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
-		if err := db.activeFile.Sync(); err != nil {
+	// value 超过阈值时单独写到 blob 文件中，数据文件中只记录 blob 的位置
+	if db.options.BlobThreshold > 0 && logRecord.Type == data.LogRecordNormal &&
+		uint(len(logRecord.Value)) > db.options.BlobThreshold {
+		blobPos, err := db.appendBlob(logRecord)
+		if err != nil {
 			return nil, err
 		}
+		logRecord = &data.LogRecord{
+			Key:    logRecord.Key,
+			Value:  data.EncodeLogRecordPos(blobPos),
+			Type:   data.LogRecordBlobPointer,
+			Expire: logRecord.Expire,
+		}
+	}
 
-		// 当前活跃文件转换为旧的数据文件
-		db.olderFiles[db.activeFile.FileId] = db.activeFile
-
-		// 打开新的数据文件
-		if err := db.setActiveDataFile(); err != nil {
+	// 写入数据编码
+	encRecord, size := db.codec.EncodeLogRecord(logRecord)
+	// 如果写入的数据已经到达了活跃文件的阈值，则关闭活跃文件，并打开新文件写
+	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+		if err := db.rotateActiveDataFile(); err != nil {
 			return nil, err
 		}
 	}
@@ -420,25 +754,19 @@ func (db *DB) appendLogRecord(logRecord *data.LogRecord) (*data.LogRecordPos, er
 		return nil, err
 	}
 
-	db.bytesWrite += uint(size)
-
-	// 根据用户配置决定是否持久化，每 BytesPerSync 个字节持久化一次
-	var needSync = db.options.SyncWrites
-	if !needSync && db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
-		needSync = true
+	// 构造内存索引信息
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: size, Expire: logRecord.Expire}
+	if logRecord.Type == data.LogRecordBlobPointer {
+		pos.Blob = data.DecodeLogRecordPos(logRecord.Value)
 	}
-
-	if needSync {
-		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
-		}
-		if db.bytesWrite > 0 {
-			db.bytesWrite = 0
-		}
+	if err := db.addActiveHint(logRecord, pos); err != nil {
+		return nil, err
 	}
 
-	// 构造内存索引信息
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: size}
+	db.bytesWrite += uint(size)
+	atomic.AddUint64(&db.metrics.bytesWritten, size)
+	db.countWriteForAutoMerge()
+
 	return pos, nil
 }
 
@@ -456,39 +784,54 @@ func (db *DB) setActiveDataFile() error {
 	if err != nil {
 		return err
 	}
+	dataFile.Codec = db.codec
 	db.activeFile = dataFile
+
+	// 新的活跃文件对应的 hint 文件
+	if db.hintEnabled() {
+		hint, err := newHintWriter(db.options.DirPath, initialFileId, db.codec)
+		if err != nil {
+			return err
+		}
+		db.activeHint = hint
+	}
 	return nil
 }
 
-// 从磁盘中加载数据文件
-func (db *DB) loadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+// 保存当前事务序列号
+func (db *DB) saveSeqNum() error {
+	seqNumFile, err := data.OpenSeqNumFile(db.options.DirPath)
 	if err != nil {
 		return err
 	}
+	defer seqNumFile.Close()
+	seqNumFile.Codec = db.codec
 
-	var fileIds []int
+	record := &data.LogRecord{
+		Key:   []byte(seqNumKey),
+		Value: []byte(strconv.FormatUint(db.seqNum, 10)),
+	}
 
-	// 遍历目录中的所有文件，找到所有以 .data 结尾的文件
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
-			// 0000001.data
-			splitNames := strings.Split(entry.Name(), ".")
-			fileID, err := strconv.Atoi(splitNames[0])
-			if err != nil {
-				return ErrDataDirectoryCorrupted
-			}
-			fileIds = append(fileIds, fileID)
-		}
+	encRecord, _ := db.codec.EncodeLogRecord(record)
+	if err := seqNumFile.Write(encRecord); err != nil {
+		return err
 	}
+	return seqNumFile.Sync()
+}
 
-	sort.Ints(fileIds)
+// 从磁盘中加载数据文件
+func (db *DB) loadDataFiles() error {
+	fileIds, err := db.readFileIds(data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
 	db.fileIds = fileIds
 
 	// 遍历每个文件 id，打开对应的数据文件，找到 id 最大的，就是活跃文件
 	for i, fid := range fileIds {
-		ioType := fio.StandardFIO
-		if db.options.MMapAtStartup {
+		// 只读模式下不使用 mmap，mmap 需要以读写方式打开文件
+		ioType := db.fileIOType()
+		if db.options.MMapAtStartup && !db.options.ReadOnly {
 			ioType = fio.MemoryMap
 		}
 
@@ -496,6 +839,7 @@ func (db *DB) loadDataFiles() error {
 		if err != nil {
 			return err
 		}
+		dataFile.Codec = db.codec
 
 		// 当前活跃文件
 		if i == len(fileIds)-1 {
@@ -507,6 +851,28 @@ func (db *DB) loadDataFiles() error {
 	return nil
 }
 
+// 找到数据目录中所有以 suffix 结尾的文件，返回从小到大排序的文件 id
+func (db *DB) readFileIds(suffix string) ([]int, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var fileIds []int
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), suffix) {
+			// 0000001.data
+			fileID, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), suffix))
+			if err != nil {
+				return nil, ErrDataDirectoryCorrupted
+			}
+			fileIds = append(fileIds, fileID)
+		}
+	}
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
 // 从数据文件中加载索引
 // 遍历文件中的所有记录，并更新到内存索引中
 func (db *DB) loadIndexFromDataFiles() error {
@@ -527,93 +893,104 @@ func (db *DB) loadIndexFromDataFiles() error {
 		nonMergeFileId = fid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += pos.Size
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
-
-		// TODO: correct? if oldPos != nil and type == deleted
-		if oldPos != nil {
-			db.reclaimSize += oldPos.Size
-		}
-	}
-
+	db.nonMergeFileId = nonMergeFileId
 	// 暂存事务数据，需要存储 LogRecord 和位置索引信息
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	// 更新最新的事务序列号
-	var currentseqNum = nonTransactionSeqNum
+	db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
 
-	// 遍历所有的文件 id，处理文件中的内容
-	for i, fileId := range db.fileIds {
+	// 取出需要加载的文件，如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
+	var dataFiles []*data.DataFile
+	for _, fileId := range db.fileIds {
 		var fileId = uint32(fileId)
-		// 如果比最近未参与 merge 的文件 id 更小，则说明已经从 Hint 文件中加载索引了
 		if hasMerge && fileId < nonMergeFileId {
 			continue
 		}
-
-		var dataFile *data.DataFile
 		if fileId == db.activeFile.FileId {
-			dataFile = db.activeFile
+			dataFiles = append(dataFiles, db.activeFile)
 		} else {
-			dataFile = db.olderFiles[fileId]
+			dataFiles = append(dataFiles, db.olderFiles[fileId])
 		}
+	}
 
-		var offset uint64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			// 两种错误，异常或者读到文件末尾
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return err
-			}
+	// 并行读取每个文件的索引信息，按照文件 id 从小到大的顺序更新到内存索引中
+	now := time.Now().UnixNano()
+	done := make(chan struct{})
+	defer close(done)
+	results, next := db.readFileHints(dataFiles, done)
 
-			// 构建内存索引并保存
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: size}
+	for i, dataFile := range dataFiles {
+		hints := <-results[i]
+		next()
+		if err := db.recoverDataFile(dataFile, hints); err != nil {
+			return err
+		}
 
-			// 解析 key, 拿到事务号
-			realKey, seqNum := parseLogRecordKey(logRecord.Key)
-			if seqNum == nonTransactionSeqNum {
-				// 非事务操作，直接更新内存索引
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// 事务完成，对应的 seqNum 的数据可以更新到内存索引中
-				if logRecord.Type == data.LogRecordTxnFinished {
-					for _, txnRecord := range transactionRecords[seqNum] {
-						updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
-					}
-					delete(transactionRecords, seqNum)
-				} else {
-					logRecord.Key = realKey
-					transactionRecords[seqNum] = append(transactionRecords[seqNum], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
-				}
-			}
+		db.applyFileHints(hints.records, now)
 
-			// 更新事务序列号
-			if seqNum > currentseqNum {
-				currentseqNum = seqNum
+		// 如果是当前活跃文件，更新这个文件的 WriteOff，并重新生成对应的 hint 文件
+		if dataFile == db.activeFile {
+			db.activeFile.WriteOff = hints.size
+			if err := db.resetActiveHint(hints.records); err != nil {
+				return err
 			}
+		}
+	}
 
-			// 读取下一个 LogRecord
-			offset += size
+	// 只读模式下继续读取写入者新写入的数据时，需要接着处理还没有完成的事务
+	if !db.options.ReadOnly {
+		db.pendingTxns = nil
+	}
+	return nil
+}
+
+// 将一个文件中的记录按照顺序更新到内存索引中，事务数据在读到事务完成的标识之后再更新
+// 在访问此方法前必须持有互斥锁
+func (db *DB) applyFileHints(records []*hintRecord, now int64) {
+	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		var oldPos *data.LogRecordPos
+		// 已经过期的数据和删除的数据一样处理
+		if typ == data.LogRecordDeleted || pos.IsExpired(now) {
+			oldPos, _ = db.index.Delete(key)
+			db.reclaim(pos)
+		} else {
+			oldPos = db.index.Put(key, pos)
 		}
-		// 如果是当前活跃文件，更新这个文件的 WriteOff
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+
+		// TODO: correct? if oldPos != nil and type == deleted
+		if oldPos != nil {
+			db.reclaim(oldPos)
 		}
 	}
 
-	// 更新 db 事务序列号
-	db.seqNum = currentseqNum
-	return nil
+	for _, record := range records {
+		// 解析 key, 拿到事务号
+		realKey, seqNum := parseLogRecordKey(record.key)
+		if seqNum == nonTransactionSeqNum && record.typ == data.LogRecordRangeDeleted {
+			// 范围删除，删除之前写入的区间中的所有 key
+			db.deleteIndexRange(realKey, record.end)
+			db.reclaim(record.pos)
+		} else if seqNum == nonTransactionSeqNum {
+			// 非事务操作，直接更新内存索引
+			updateIndex(realKey, record.typ, record.pos)
+		} else {
+			// 事务完成，对应的 seqNum 的数据可以更新到内存索引中
+			if record.typ == data.LogRecordTxnFinished {
+				for _, txnRecord := range db.pendingTxns[seqNum] {
+					updateIndex(txnRecord.Record.Key, txnRecord.Record.Type, txnRecord.Pos)
+				}
+				delete(db.pendingTxns, seqNum)
+			} else {
+				db.pendingTxns[seqNum] = append(db.pendingTxns[seqNum], &data.TransactionRecord{
+					Record: &data.LogRecord{Key: realKey, Type: record.typ},
+					Pos:    record.pos,
+				})
+			}
+		}
+
+		// 更新事务序列号
+		if seqNum > db.seqNum {
+			db.seqNum = seqNum
+		}
+	}
 }
 
 func checkOptions(options Options) error {
@@ -629,6 +1006,31 @@ func checkOptions(options Options) error {
 		return errors.New("invalid merge ratio, must between 0 and 1")
 	}
 
+	if options.Compression > GzipCompression {
+		return errors.New("unsupported compression type")
+	}
+
+	for _, w := range options.AutoMergeWindows {
+		if w.Start < 0 || w.Start >= 24*time.Hour || w.End < 0 || w.End > 24*time.Hour || w.Start == w.End {
+			return errors.New("invalid auto merge window, must be within a day")
+		}
+	}
+
+	// 订阅变更的序列号中数据文件的偏移只有 32 位
+	if options.DataFileSize > math.MaxUint32 {
+		return errors.New("database data file size must be less than 4GB")
+	}
+
+	if options.ReadOnly && options.IndexType == BPlusTree {
+		return errors.New("read-only mode does not support the B+ tree index")
+	}
+
+	for _, key := range append([][]byte{options.EncryptionKey}, options.PreviousEncryptionKeys...) {
+		if n := len(key); n != 0 && n != 16 && n != 24 && n != 32 {
+			return errors.New("invalid encryption key, must be 16, 24 or 32 bytes")
+		}
+	}
+
 	return nil
 }
 
@@ -643,6 +1045,7 @@ func (db *DB) loadSeqNum() error {
 	if err != nil {
 		return err
 	}
+	seqNumFile.Codec = db.codec
 
 	record, _, err := seqNumFile.ReadLogRecord(0)
 	if err != nil {
@@ -669,12 +1072,12 @@ func (db *DB) resetIoType() error {
 		return nil
 	}
 
-	if err := db.activeFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+	if err := db.activeFile.SetIOManager(db.options.DirPath, db.fileIOType()); err != nil {
 		return err
 	}
 
 	for _, dataFile := range db.olderFiles {
-		if err := dataFile.SetIOManager(db.options.DirPath, fio.StandardFIO); err != nil {
+		if err := dataFile.SetIOManager(db.options.DirPath, db.fileIOType()); err != nil {
 			return err
 		}
 	}
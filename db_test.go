@@ -2,7 +2,9 @@ package bitcask_go
 
 import (
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -98,6 +100,58 @@ func TestDB_Put(t *testing.T) {
 	assert.Equal(t, val4, val5)
 }
 
+/*
+1. 设置了过期时间的数据在过期前可以读取
+2. 过期后 Get、ListKeys、Fold、迭代器都看不到
+3. 重启后过期的数据依然不可见
+*/
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 1.过期前可以读取
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 50*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	// 2.过期后不可见
+	time.Sleep(100 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, [][]byte{utils.GetTestKey(2)}, db.ListKeys())
+
+	var count int
+	err = db.Fold(func(key []byte, value []byte) bool {
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	assert.True(t, iter.Valid())
+	assert.Equal(t, utils.GetTestKey(2), iter.Key())
+	iter.Close()
+
+	// 3.重启后依然不可见
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(db2.ListKeys()))
+}
+
 /*
 1. 正常读取一个数据
 2. 读取一个 key 不存在的数据
@@ -413,3 +467,110 @@ func TestDB_Backup(t *testing.T) {
 //	assert.Nil(t, err)
 //	assert.NotNil(t, db)
 //}
+
+// 压缩的数据可以正常读取，修改压缩算法之后旧数据依然可读，merge 之后按照新算法重写
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := []byte(strings.Repeat(`{"name":"bitcask-go","type":"kv"}`, 20))
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), value)
+		assert.Nil(t, err)
+	}
+	plainSize := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 使用 gzip 重新打开，旧的数据依然可读
+	opts.Compression = GzipCompression
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// merge 之后按照 gzip 重写
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	fileSize, err := db3.olderFiles[0].IoManager.Size()
+	assert.Nil(t, err)
+	assert.Less(t, fileSize*5, plainSize)
+	err = db3.Close()
+	assert.Nil(t, err)
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.EncryptionKey = []byte("0123456789abcdef")
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(0), []byte("bitcask-go"))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 不使用密钥或者使用错误的密钥打开
+	plainOpts := opts
+	plainOpts.EncryptionKey = nil
+	_, err = Open(plainOpts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+	wrongOpts := opts
+	wrongOpts.EncryptionKey = []byte("fedcba9876543210")
+	_, err = Open(wrongOpts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+
+	// 轮换密钥，merge 之后只需要新的密钥
+	rotateOpts := opts
+	rotateOpts.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	rotateOpts.PreviousEncryptionKeys = [][]byte{opts.EncryptionKey}
+	db2, err := Open(rotateOpts)
+	assert.Nil(t, err)
+	err = db2.Merge()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	rotateOpts.PreviousEncryptionKeys = nil
+	db3, err := Open(rotateOpts)
+	assert.Nil(t, err)
+	val, err := db3.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), val)
+	for i := 1; i < 1000; i++ {
+		_, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 旧的密钥已经无法读取数据
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+}
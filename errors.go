@@ -1,6 +1,10 @@
 package bitcask_go
 
-import "errors"
+import (
+	"errors"
+
+	"bitcask-go/data"
+)
 
 var (
 	ErrKeyIsEmpty             = errors.New("the key is empty")
@@ -8,9 +12,34 @@ var (
 	ErrKeyNotFound            = errors.New("key not found in database")
 	ErrDataFileNotFound       = errors.New("data file not found")
 	ErrDataDirectoryCorrupted = errors.New("the database directory maybe corrupted")
+	ErrDataFileCorrupted      = errors.New("the data file is corrupted, open with RepairCorruption to truncate it")
 	ErrExceedMaxBatchNum      = errors.New("exceed the maxmium num")
 	ErrMergeIsProgress        = errors.New("merge is in progress, try again later")
 	ErrDatabaseIsUsing        = errors.New("the database directory is used by another process")
 	ErrMergeRatioUnreached    = errors.New("the merge ratio do not reach the option")
 	ErrNoEnoughSpaceForMerge  = errors.New("no enough disk space for merge")
+	ErrInvalidMergePolicy     = errors.New("invalid merge policy, garbage ratio must between 0 and 1")
+	ErrMergeNotApplied        = errors.New("the previous merge has not been applied, reopen the database")
+	ErrMergeFilesOverflow     = errors.New("merge produced more data files than it replaces")
+	ErrSnapshotClosed         = errors.New("the snapshot is closed")
+	ErrTxnConflict            = errors.New("transaction conflict, the keys it read were changed by another commit")
+	ErrTxnClosed              = errors.New("the transaction is already committed or rolled back")
+	ErrInvalidEncryptionKey   = data.ErrInvalidEncryptionKey
+	ErrBackupManifestNotFound = errors.New("backup manifest not found in the backup directory")
+	ErrBackupCorrupted        = errors.New("the backup is corrupted, file size or checksum mismatch")
+	ErrRestoreDirNotEmpty     = errors.New("the restore target directory is not empty")
+	ErrOrphanedTxnRecord      = errors.New("transaction record without a finished mark")
+	ErrInvalidHintEntry       = errors.New("hint entry points to an invalid position")
+	ErrRepairDirNotEmpty      = errors.New("the repair target directory is not empty")
+	ErrReadOnly               = errors.New("the database is opened read-only")
+	ErrManifestCorrupted      = errors.New("the MANIFEST file is corrupted")
+	ErrUnsupportedFormat      = errors.New("the data directory was created by a newer version")
+	ErrFormatUpgradeRequired  = errors.New("the data directory format is outdated, open it read-write once to upgrade")
+	ErrIndexTypeMismatch      = errors.New("the index type does not match the one the data directory was created with")
+	ErrWatchSeqCompacted      = errors.New("the data at the watch sequence has been rewritten by merge or blob gc")
+	ErrInvalidWatchSeq        = errors.New("the watch sequence does not point into the data files")
+	ErrWatchQueueFull         = errors.New("the watcher fell too far behind and was closed")
+	ErrValueNotInteger        = errors.New("the value is not an integer")
+	ErrIncrementOverflow      = errors.New("increment would overflow")
+	ErrPreconditionFailed     = errors.New("the write batch precondition failed")
 )
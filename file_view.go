@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"bitcask-go/data"
+)
+
+// 读取数据时持有的数据文件集合
+// merge 或者 BlobGC 替换掉的旧文件，要等到之前创建的所有 fileView 都释放之后才会关闭
+type fileView struct {
+	files   map[uint32]*data.DataFile // 数据文件
+	blobs   map[uint32]*data.DataFile // blob 文件
+	version uint64                    // 创建时的文件版本
+	cache   *valueCache               // 读取 value 的缓存
+}
+
+// 被替换掉的文件，version 是替换之后的文件版本
+type retiredFile struct {
+	file    *data.DataFile
+	version uint64
+}
+
+// 创建 fileView，使用完之后需要调用 releaseFileView
+// 在访问此方法前必须持有互斥锁（读锁即可）
+func (db *DB) newFileView() *fileView {
+	files := make(map[uint32]*data.DataFile, len(db.olderFiles)+1)
+	for fid, file := range db.olderFiles {
+		files[fid] = file
+	}
+	if db.activeFile != nil {
+		files[db.activeFile.FileId] = db.activeFile
+	}
+	blobs := make(map[uint32]*data.DataFile, len(db.blobFiles))
+	for fid, file := range db.blobFiles {
+		blobs[fid] = file
+	}
+
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+	db.fileRefs[db.fileVersion]++
+	return &fileView{files: files, blobs: blobs, version: db.fileVersion, cache: db.valueCache}
+}
+
+// 释放 fileView，关闭已经没有读者的旧文件
+func (db *DB) releaseFileView(view *fileView) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+
+	db.fileRefs[view.version]--
+	if db.fileRefs[view.version] <= 0 {
+		delete(db.fileRefs, view.version)
+	}
+	db.closeRetiredFiles()
+}
+
+// 替换掉旧的文件，旧文件在没有读者使用之后关闭
+// 在访问此方法前必须持有互斥锁
+func (db *DB) retireFiles(files []*data.DataFile) {
+	db.refMu.Lock()
+	defer db.refMu.Unlock()
+
+	db.fileVersion++
+	for _, file := range files {
+		db.retiredFiles = append(db.retiredFiles, retiredFile{file: file, version: db.fileVersion})
+	}
+	db.closeRetiredFiles()
+}
+
+// 关闭所有读者都已经释放的旧文件
+// 在访问此方法前必须持有 refMu
+func (db *DB) closeRetiredFiles() {
+	// 版本小于 minVersion 的读者都已经释放
+	var minVersion = db.fileVersion
+	for version := range db.fileRefs {
+		if version < minVersion {
+			minVersion = version
+		}
+	}
+
+	var remains []retiredFile
+	var closed []*data.DataFile
+	for _, retired := range db.retiredFiles {
+		// version 之前创建的读者会使用这个文件
+		if retired.version > minVersion {
+			remains = append(remains, retired)
+			continue
+		}
+		_ = retired.file.Close()
+		closed = append(closed, retired.file)
+	}
+	db.retiredFiles = remains
+	// 已经没有读者会读取这些文件，删除对应的缓存
+	db.valueCache.removeFiles(closed)
+}
+
+// 从 fileView 持有的文件中读取 value
+func (view *fileView) getValuesByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if logRecordPos.Blob != nil {
+		return view.cache.read(view.blobs[logRecordPos.Blob.Fid], logRecordPos.Blob)
+	}
+	return view.cache.read(view.files[logRecordPos.Fid], logRecordPos)
+}
@@ -23,6 +23,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// NewReadOnlyFileIOManager 以只读方式打开已经存在的文件，写入会返回错误
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.OpenFile(fileName, os.O_RDONLY, DataFilePerm)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
@@ -95,3 +95,28 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+	path := filepath.Join("/tmp/kv-go", "a.data")
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	fio, err := NewFileIOManager(path)
+	defer destroyFile(path)
+	assert.Nil(t, err)
+	_, err = fio.Write([]byte("bitcask kv"))
+	assert.Nil(t, err)
+
+	roFio, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	defer roFio.Close()
+
+	_, err = roFio.Write([]byte("key-a"))
+	assert.NotNil(t, err)
+
+	b := make([]byte, 10)
+	n, err := roFio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, []byte("bitcask kv"), b)
+}
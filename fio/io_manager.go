@@ -10,6 +10,9 @@ const (
 
 	// MemoryMap 内存文件映射
 	MemoryMap
+
+	// ReadOnlyFIO 只读的标准文件 IO，文件必须已经存在
+	ReadOnlyFIO
 )
 
 // IOManager 抽象 IO 管理接口，可以接入不同的 IO 类型，比如 mmap，当前只支持标准 IO
@@ -35,6 +38,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type ")
 	}
@@ -16,7 +16,6 @@ require (
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/gofrs/flock v0.8.1
-	github.com/plar/go-adaptive-radix-tree v1.0.5
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/tidwall/redcon v1.6.2
 	go.etcd.io/bbolt v1.3.8
@@ -0,0 +1,109 @@
+package bitcask_go
+
+import "bitcask-go/data"
+
+// 等待组提交的写入
+type commitRequest struct {
+	write  func() error // 写入数据文件，不进行持久化
+	update func() error // 持久化之后更新内存索引
+	err    error
+	wake   chan bool // true 表示已经被其他 leader 提交，false 表示成为新的 leader
+}
+
+// 写入数据之后更新内存索引
+// 配置了 SyncWrites 时并发的写入会排队，由一个 leader 依次写入所有排队的数据并只持久化一次，
+// 每个写入者都在自己的数据持久化之后才返回，内存索引也只在持久化之后才更新
+func (db *DB) commitWrite(write, update func() error) error {
+	return db.commitWriteWithSync(db.options.SyncWrites, write, update)
+}
+
+// 同 commitWrite，sync 为 true 时经过组提交并持久化，WriteBatch 可以单独配置是否持久化
+func (db *DB) commitWriteWithSync(sync bool, write, update func() error) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if !sync {
+		db.mu.Lock()
+		defer db.mu.Unlock()
+
+		if err := write(); err != nil {
+			return err
+		}
+		// 每 BytesPerSync 个字节持久化一次
+		if db.options.BytesPerSync > 0 && db.bytesWrite >= db.options.BytesPerSync {
+			if err := db.syncWrites(); err != nil {
+				return err
+			}
+		}
+		return update()
+	}
+
+	req := &commitRequest{write: write, update: update, wake: make(chan bool, 1)}
+	db.commitMu.Lock()
+	db.commitQueue = append(db.commitQueue, req)
+	if db.committing {
+		db.commitMu.Unlock()
+		// 等待 leader 提交，或者轮到自己成为 leader
+		if committed := <-req.wake; committed {
+			return req.err
+		}
+	} else {
+		db.committing = true
+		db.commitMu.Unlock()
+	}
+
+	// 成为 leader，取出所有排队的写入，包括自己
+	db.commitMu.Lock()
+	group := db.commitQueue
+	db.commitQueue = nil
+	db.commitMu.Unlock()
+
+	db.commitGroup(group)
+
+	// 提交期间又有新的写入排队，交给排在最前面的写入者继续提交
+	db.commitMu.Lock()
+	if len(db.commitQueue) > 0 {
+		db.commitQueue[0].wake <- false
+	} else {
+		db.committing = false
+	}
+	db.commitMu.Unlock()
+
+	for _, r := range group {
+		if r != req {
+			r.wake <- true
+		}
+	}
+	return req.err
+}
+
+// 依次写入一组数据，持久化一次之后再更新内存索引
+func (db *DB) commitGroup(group []*commitRequest) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 组内之后的写入需要看到之前写入的数据
+	db.stagedWrites = make(map[string]*data.LogRecordPos)
+	defer func() { db.stagedWrites = nil }()
+
+	var written []*commitRequest
+	for _, req := range group {
+		if req.err = req.write(); req.err == nil {
+			written = append(written, req)
+		}
+	}
+	if len(written) == 0 {
+		return
+	}
+
+	if err := db.syncWrites(); err != nil {
+		for _, req := range written {
+			req.err = err
+		}
+		return
+	}
+	for _, req := range written {
+		req.err = req.update()
+	}
+}
@@ -0,0 +1,125 @@
+package bitcask_go
+
+import (
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_GroupCommit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 并发写入，每个写入返回之后都已经持久化并且可以读取
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g * 100; i < (g+1)*100; i++ {
+				err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+				assert.Nil(t, err)
+				val, err := db.Get(utils.GetTestKey(i))
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+				if i%2 == 0 {
+					err = db.Delete(utils.GetTestKey(i))
+					assert.Nil(t, err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.Equal(t, 800, len(db.ListKeys()))
+	assert.Equal(t, uint(0), db.bytesWrite)
+	assert.Empty(t, db.commitQueue)
+	assert.False(t, db.committing)
+
+	// 删除不存在的 key 不写入数据
+	writeOff := db.activeFile.WriteOff
+	err = db.Delete([]byte("unknown"))
+	assert.Nil(t, err)
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 800, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err := db2.Get(utils.GetTestKey(1599))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1599), val)
+}
+
+// WriteBatch 和事务的提交同样经过组提交，每次提交最多持久化一次
+func TestDB_GroupCommit_Batch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-group-commit-batch")
+	opts.DirPath = dir
+	opts.SyncWrites = true
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	fsyncs := db.Metrics().Fsyncs
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commmit())
+	assert.Equal(t, fsyncs+1, db.Metrics().Fsyncs)
+
+	fsyncs = db.Metrics().Fsyncs
+	txn := db.Begin()
+	assert.Nil(t, txn.Put([]byte("txn"), []byte("value")))
+	assert.Nil(t, txn.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, txn.Commit())
+	assert.Equal(t, fsyncs+1, db.Metrics().Fsyncs)
+
+	// 并发提交的批次和事务共享持久化，同一个 key 的自增不会丢失
+	fsyncs = db.Metrics().Fsyncs
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+				assert.Nil(t, wb.Put(utils.GetTestKey(1000+g*50+i), []byte("value")))
+				assert.Nil(t, wb.Increment([]byte("counter"), 1))
+				assert.Nil(t, wb.Commmit())
+			}
+		}(g)
+	}
+	wg.Wait()
+	assert.True(t, db.Metrics().Fsyncs-fsyncs <= 800)
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), val)
+	assert.Equal(t, uint(0), db.bytesWrite)
+	assert.Empty(t, db.commitQueue)
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 811, len(db2.ListKeys()))
+	val, err = db2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("800"), val)
+}
@@ -0,0 +1,258 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// 活跃 hint 文件的写缓冲大小，达到之后写入文件
+const hintBufferSize = 64 * 1024
+
+// 数据文件中一条记录的索引信息，和数据文件中的记录一一对应
+type hintRecord struct {
+	key []byte // 带事务序列号的 key
+	typ data.LogRecordType
+	pos *data.LogRecordPos
+	end []byte // 范围删除的结束位置，hint 文件中不存储，需要从数据文件中读取
+}
+
+// 活跃数据文件对应的 hint 文件
+// 每写入一条记录追加一条索引信息，数据文件写满之后随之封存，重启时可以代替数据文件加载索引
+type hintWriter struct {
+	file  *data.DataFile
+	codec *data.Codec
+	buf   []byte
+}
+
+// 为数据文件创建新的 hint 文件，已经存在的旧文件会被删除
+func newHintWriter(dirPath string, fileId uint32, codec *data.Codec) (*hintWriter, error) {
+	if err := os.Remove(data.GetHintFileName(dirPath, fileId)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	file, err := data.OpenDataHintFile(dirPath, fileId, fio.StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	file.Codec = codec
+	return &hintWriter{file: file, codec: codec}, nil
+}
+
+// 追加一条索引信息
+func (w *hintWriter) add(record *hintRecord) error {
+	encRecord, _ := w.codec.EncodeLogRecord(&data.LogRecord{
+		Key:   record.key,
+		Value: data.EncodeLogRecordPos(record.pos),
+		Type:  record.typ,
+	})
+	w.buf = append(w.buf, encRecord...)
+	if len(w.buf) >= hintBufferSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *hintWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.file.Write(w.buf); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// 数据文件写满之后封存 hint 文件
+func (w *hintWriter) seal() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *hintWriter) close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.file.Close()
+}
+
+// 是否为数据文件维护 hint 文件，B+ 树索引存储在磁盘上，启动时不需要加载
+func (db *DB) hintEnabled() bool {
+	return db.options.IndexType != BPlusTree
+}
+
+// 记录写入活跃数据文件的索引信息
+// 在访问此方法前必须持有互斥锁
+func (db *DB) addActiveHint(logRecord *data.LogRecord, pos *data.LogRecordPos) error {
+	if db.activeHint == nil {
+		return nil
+	}
+	return db.activeHint.add(&hintRecord{key: logRecord.Key, typ: logRecord.Type, pos: pos})
+}
+
+// 当前活跃文件转换为旧的数据文件，并封存对应的 hint 文件，然后打开新的活跃文件
+// 在访问此方法前必须持有互斥锁
+func (db *DB) rotateActiveDataFile() error {
+	start := time.Now()
+	if err := db.activeFile.Sync(); err != nil {
+		return err
+	}
+	db.metrics.fsync.since(start)
+	atomic.AddUint64(&db.metrics.fileRotations, 1)
+	if db.activeHint != nil {
+		if err := db.activeHint.seal(); err != nil {
+			return err
+		}
+		db.activeHint = nil
+	}
+
+	// 当前活跃文件转换为旧的数据文件
+	db.olderFiles[db.activeFile.FileId] = db.activeFile
+
+	// 打开新的数据文件
+	return db.setActiveDataFile()
+}
+
+// 一个数据文件加载出来的所有索引信息
+type fileHints struct {
+	records []*hintRecord
+	size    uint64 // 有效数据的末尾位置
+	err     error
+}
+
+// 并行读取数据文件中的索引信息，按照文件的顺序返回
+// 最多同时持有 NumCPU 个文件的结果，调用方处理完一个文件之后调用 next 才会继续读取
+func (db *DB) readFileHints(dataFiles []*data.DataFile, done <-chan struct{}) ([]chan *fileHints, func()) {
+	results := make([]chan *fileHints, len(dataFiles))
+	for i := range results {
+		results[i] = make(chan *fileHints, 1)
+	}
+
+	tokens := make(chan struct{}, runtime.NumCPU())
+	go func() {
+		for i, dataFile := range dataFiles {
+			select {
+			case tokens <- struct{}{}:
+			case <-done:
+				return
+			}
+			// 活跃文件可能没有完整的 hint 文件，直接读取数据文件
+			useHint := i < len(dataFiles)-1
+			go func(i int, dataFile *data.DataFile) {
+				results[i] <- db.loadFileHints(dataFile, useHint)
+			}(i, dataFile)
+		}
+	}()
+	return results, func() { <-tokens }
+}
+
+// 读取数据文件的索引信息，优先使用 hint 文件，hint 文件不存在或者不完整时读取数据文件
+func (db *DB) loadFileHints(dataFile *data.DataFile, useHint bool) *fileHints {
+	if useHint && db.hintEnabled() {
+		if hints := db.readHintFile(dataFile); hints != nil {
+			return hints
+		}
+	}
+	return scanDataFile(dataFile, 0)
+}
+
+// 读取数据文件对应的 hint 文件，hint 文件必须覆盖整个数据文件，否则返回 nil
+func (db *DB) readHintFile(dataFile *data.DataFile) *fileHints {
+	hintFileName := data.GetHintFileName(db.options.DirPath, dataFile.FileId)
+	if _, err := os.Stat(hintFileName); err != nil {
+		return nil
+	}
+	hintFile, err := data.OpenDataHintFile(db.options.DirPath, dataFile.FileId, db.fileIOType())
+	if err != nil {
+		return nil
+	}
+	defer hintFile.Close()
+	hintFile.Codec = db.codec
+
+	hints := &fileHints{}
+	var offset uint64 = 0
+	for {
+		logRecord, size, err := hintFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil
+		}
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if pos.Fid != dataFile.FileId || pos.Offset != hints.size {
+			return nil
+		}
+		record := &hintRecord{key: logRecord.Key, typ: logRecord.Type, pos: pos}
+		if record.typ == data.LogRecordRangeDeleted {
+			rangeRecord, _, err := dataFile.ReadLogRecord(pos.Offset)
+			if err != nil {
+				return nil
+			}
+			record.end = rangeRecord.Value
+		}
+		hints.records = append(hints.records, record)
+		hints.size = pos.Offset + pos.Size
+		offset += size
+	}
+
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil || uint64(fileSize) != hints.size {
+		return nil
+	}
+	return hints
+}
+
+// 从 offset 开始遍历数据文件中的所有记录
+func scanDataFile(dataFile *data.DataFile, offset uint64) *fileHints {
+	hints := &fileHints{size: offset}
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(hints.size)
+		// 两种错误，异常或者读到文件末尾
+		if err != nil {
+			if err != io.EOF {
+				hints.err = err
+			}
+			return hints
+		}
+
+		pos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: hints.size, Size: size, Expire: logRecord.Expire}
+		record := &hintRecord{key: logRecord.Key, typ: logRecord.Type, pos: pos}
+		switch logRecord.Type {
+		case data.LogRecordBlobPointer:
+			pos.Blob = data.DecodeLogRecordPos(logRecord.Value)
+		case data.LogRecordRangeDeleted:
+			record.end = logRecord.Value
+		}
+		hints.records = append(hints.records, record)
+		hints.size += size
+	}
+}
+
+// 重新生成活跃文件的 hint 文件，活跃文件在上次关闭之前的 hint 文件可能不完整
+func (db *DB) resetActiveHint(records []*hintRecord) error {
+	if !db.hintEnabled() || db.options.ReadOnly {
+		return nil
+	}
+	hint, err := newHintWriter(db.options.DirPath, db.activeFile.FileId, db.codec)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		if err := hint.add(record); err != nil {
+			return err
+		}
+	}
+	db.activeHint = hint
+	return nil
+}
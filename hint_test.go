@@ -0,0 +1,140 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_FileHint(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-hint")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 1000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.PutWithTTL([]byte("ttl"), []byte("value"), time.Hour)
+	assert.Nil(t, err)
+
+	// 跨越多个数据文件的事务
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 3000; i < 5000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = wb.Commmit()
+	assert.Nil(t, err)
+
+	activeFid := db.activeFile.FileId
+	assert.True(t, activeFid > 2)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 每个写满的数据文件都有对应的 hint 文件
+	for fid := uint32(0); fid < activeFid; fid++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.Nil(t, err)
+	}
+
+	check := func(db *DB) {
+		assert.Equal(t, 4001, len(db.ListKeys()))
+		_, err := db.Get(utils.GetTestKey(999))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get(utils.GetTestKey(4999))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(4999), val)
+		val, err = db.Get([]byte("ttl"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 不完整的 hint 文件会被忽略，从数据文件中加载
+	err = os.Truncate(data.GetHintFileName(dir, 1), 100)
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	check(db3)
+	err = db3.Close()
+	assert.Nil(t, err)
+
+	// 存在 hint 文件时不会读取数据文件
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("x"), 20)
+	assert.Nil(t, err)
+	_ = f.Close()
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+
+	err = os.Remove(data.GetHintFileName(dir, 0))
+	assert.Nil(t, err)
+	db, err = Open(opts)
+	assert.Equal(t, ErrDataFileCorrupted, err)
+	assert.Nil(t, db)
+	_ = os.RemoveAll(dir)
+}
+
+func TestDB_FileHint_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-hint-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 5000; i++ {
+		err := db.Put(utils.GetTestKey(i%1000), utils.RandomValue(24))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 参与 merge 的文件的 hint 文件被删除，由 merge 产生的 hint 索引文件代替
+	nonMergeFileId := db.activeFile.FileId
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		_, err := os.Stat(data.GetHintFileName(dir, fid))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	for i := 0; i < 3000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 3000, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), val)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	bitcask "bitcask-go"
 )
@@ -37,8 +38,18 @@ func handlePut(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 	}
 
+	// 可选的过期时间，例如 ?ttl=10s
+	var ttl time.Duration
+	if ttlStr := request.URL.Query().Get("ttl"); ttlStr != "" {
+		var err error
+		if ttl, err = time.ParseDuration(ttlStr); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	for key, value := range kv {
-		if err := db.Put([]byte(key), []byte(value)); err != nil {
+		if err := db.PutWithTTL([]byte(key), []byte(value), ttl); err != nil {
 			http.Error(writer, err.Error(), http.StatusInternalServerError)
 			log.Printf("failed to put kv in db: %v\n", err)
 			return
@@ -94,6 +105,18 @@ func handleStat(writer http.ResponseWriter, request *http.Request) {
 	_ = json.NewEncoder(writer).Encode(stat)
 }
 
+func handleMetrics(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := db.Metrics().WritePrometheus(writer); err != nil {
+		log.Printf("failed to write metrics: %v\n", err)
+	}
+}
+
 func handleLiskKeys(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != http.MethodGet {
 		http.Error(writer, "Method not allowed", http.StatusInternalServerError)
@@ -115,6 +138,7 @@ func main() {
 	http.HandleFunc("/bitcask/delete", handleDelete)
 	http.HandleFunc("/bitcask/stat", handleStat)
 	http.HandleFunc("/bitcask/listkeys", handleLiskKeys)
+	http.HandleFunc("/metrics", handleMetrics)
 
 	// 启动 http 服务
 	_ = http.ListenAndServe("localhost:8080", nil)
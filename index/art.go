@@ -1,140 +1,109 @@
 package index
 
 import (
-	"bytes"
-	"sort"
 	"sync"
 
-	goart "github.com/plar/go-adaptive-radix-tree"
-
 	"bitcask-go/data"
 )
 
 // AdapativeRadixTree 自适应基数树索引
+// 基数树支持写时复制，快照和迭代器克隆的开销很小
 type AdapativeRadixTree struct {
-	tree goart.Tree
+	tree *artTree
 	lock *sync.RWMutex
 }
 
 func NewART() *AdapativeRadixTree {
 	return &AdapativeRadixTree{
-		tree: goart.New(),
+		tree: newArtTree(),
 		lock: new(sync.RWMutex),
 	}
 }
 
 func (art *AdapativeRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
-	oldVal, _ := art.tree.Insert(key, pos)
-	art.lock.Unlock()
-	if oldVal == nil {
-		return nil
-	}
-	return oldVal.(*data.LogRecordPos)
+	defer art.lock.Unlock()
+	return art.tree.put(key, pos)
 }
 
 func (art *AdapativeRadixTree) Get(key []byte) *data.LogRecordPos {
 	art.lock.RLock()
 	defer art.lock.RUnlock()
-	value, found := art.tree.Search(key)
-	if !found {
+	item := art.tree.get(key)
+	if item == nil {
 		return nil
 	}
-
-	return value.(*data.LogRecordPos)
+	return item.pos
 }
 
 func (art *AdapativeRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
-	oldVal, deleted := art.tree.Delete(key)
-	art.lock.Unlock()
-	if oldVal == nil {
-		return nil, false
-	}
-	return oldVal.(*data.LogRecordPos), deleted
+	defer art.lock.Unlock()
+	return art.tree.delete(key)
 }
 
 func (art *AdapativeRadixTree) Size() int {
 	art.lock.RLock()
-	size := art.tree.Size()
-	art.lock.RUnlock()
-	return size
+	defer art.lock.RUnlock()
+	return art.tree.size
 }
 
 func (art *AdapativeRadixTree) Close() error {
 	return nil
 }
 
+// Snapshot 基于基数树的写时复制，克隆的开销很小
+func (art *AdapativeRadixTree) Snapshot() (Indexer, error) {
+	art.lock.Lock()
+	defer art.lock.Unlock()
+	return &AdapativeRadixTree{
+		tree: art.tree.clone(),
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
 func (art *AdapativeRadixTree) Iterator(reverse bool) Iterator {
+	// 克隆需要修改原来的树的写时复制标记，需要持有写锁
 	art.lock.Lock()
 	defer art.lock.Unlock()
-	return newARTIterator(art.tree, reverse)
+	return newARTIterator(art.tree.clone(), reverse)
 }
 
-// BTree 索引迭代器
+// ART 索引迭代器
+// 遍历创建时克隆的树，每次从树中定位到上一批的最后一个 key 之后取出一批数据，内存占用和实际遍历的数据量相关
 type artIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否反向遍历
-	values    []*Item // key+位置索引信息
+	tree    *artTree      // 创建迭代器时克隆的树，之后对索引的修改对其不可见
+	reverse bool          // 是否反向遍历
+	batch   iteratorBatch // 当前取出的一批数据
 }
 
-func newARTIterator(tree goart.Tree, reverse bool) *artIterator {
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
-	}
-	// 潜在问题，可能导致内存突然膨胀
-	values := make([]*Item, tree.Size())
-	saveVaules := func(node goart.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
-		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
-		}
-		return true
-	}
-	tree.ForEach(saveVaules)
-
-	return &artIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
+func newARTIterator(tree *artTree, reverse bool) *artIterator {
+	arti := &artIterator{
+		tree:    tree,
+		reverse: reverse,
 	}
-
+	arti.Rewind()
+	return arti
 }
 
 // Rewind 重新回到迭代器的起点，第一个数据
 func (arti *artIterator) Rewind() {
-	arti.currIndex = 0
+	arti.batch.rewind(arti.load)
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据这个 key 开始遍历
 func (arti *artIterator) Seek(key []byte) {
-	if arti.reverse {
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0
-		})
-	} else {
-		arti.currIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0
-		})
-	}
-
+	arti.batch.seek(key, arti.load)
 }
 
 // Next 跳转到下一个 key
 func (arti *artIterator) Next() {
-	arti.currIndex += 1
+	arti.batch.next(arti.load)
 }
 
 // Valid 是否有效
 func (arti *artIterator) Valid() bool {
-	return arti.currIndex < len(arti.values)
+	return arti.batch.valid()
 }
 
 // Key 当前遍历位置的 key 的数据
@@ -142,15 +111,29 @@ func (arti *artIterator) Key() []byte {
 	if !arti.Valid() {
 		panic("iterator out of bound")
 	}
-	return arti.values[arti.currIndex].key
+	return arti.batch.item().key
 }
 
 // Value 当前遍历位置的 value
 func (arti *artIterator) Value() *data.LogRecordPos {
-	return arti.values[arti.currIndex].pos
+	return arti.batch.item().pos
 }
 
 // Close 关闭迭代器，释放对应的资源
 func (arti *artIterator) Close() {
-	arti.values = nil
+	arti.tree = nil
+	arti.batch = iteratorBatch{}
+}
+
+// 从 from 开始（inclusive 为 false 时不包括 from）按照遍历方向取出最多 limit 条数据
+func (arti *artIterator) load(from []byte, inclusive bool, limit int) []*Item {
+	if arti.tree == nil {
+		return nil
+	}
+	items := make([]*Item, 0, limit)
+	arti.tree.walk(from, inclusive, arti.reverse, func(item *Item) bool {
+		items = append(items, item)
+		return len(items) < limit
+	})
+	return items
 }
@@ -1,6 +1,10 @@
 package index
 
 import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -76,3 +80,171 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 24})
+
+	snap, err := art.Snapshot()
+	assert.Nil(t, err)
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 36})
+	art.Delete([]byte("key-2"))
+	art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 2, Offset: 48})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, uint32(1), snap.Get([]byte("key-1")).Fid)
+	assert.NotNil(t, snap.Get([]byte("key-2")))
+	assert.Nil(t, snap.Get([]byte("key-3")))
+	assert.Nil(t, snap.Close())
+}
+
+// 随机写入和删除，每个快照都保持创建时的数据，子节点数量跨过有序数组和 256 数组的边界
+func TestAdaptiveRadixTree_CopyOnWrite(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	longPrefix := bytes.Repeat([]byte("p"), 80)
+	randomKey := func() []byte {
+		switch r.Intn(3) {
+		case 0:
+			return []byte{byte(r.Intn(256))}
+		case 1:
+			return append(append([]byte{}, longPrefix...), byte(r.Intn(256)), byte(r.Intn(4)))
+		default:
+			return []byte(fmt.Sprintf("key-%d", r.Intn(300)))
+		}
+	}
+
+	type snapshot struct {
+		index Indexer
+		model map[string]uint64
+	}
+	art := NewART()
+	model := make(map[string]uint64)
+	var snapshots []snapshot
+	for i := 0; i < 20000; i++ {
+		key := randomKey()
+		if r.Intn(3) == 0 {
+			oldPos, ok := art.Delete(key)
+			offset, exists := model[string(key)]
+			assert.Equal(t, exists, ok)
+			if exists {
+				assert.Equal(t, offset, oldPos.Offset)
+			}
+			delete(model, string(key))
+		} else {
+			oldPos := art.Put(key, &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+			if offset, exists := model[string(key)]; exists {
+				assert.Equal(t, offset, oldPos.Offset)
+			} else {
+				assert.Nil(t, oldPos)
+			}
+			model[string(key)] = uint64(i)
+		}
+
+		if i%2000 == 0 {
+			snap, err := art.Snapshot()
+			assert.Nil(t, err)
+			copied := make(map[string]uint64, len(model))
+			for k, v := range model {
+				copied[k] = v
+			}
+			snapshots = append(snapshots, snapshot{snap, copied})
+		}
+	}
+	snapshots = append(snapshots, snapshot{art, model})
+
+	for _, snap := range snapshots {
+		assert.Equal(t, len(snap.model), snap.index.Size())
+		var keys []string
+		for key, offset := range snap.model {
+			keys = append(keys, key)
+			assert.Equal(t, offset, snap.index.Get([]byte(key)).Offset)
+		}
+		sort.Strings(keys)
+
+		var got []string
+		iter := snap.index.Iterator(false)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			got = append(got, string(iter.Key()))
+			assert.Equal(t, snap.model[string(iter.Key())], iter.Value().Offset)
+		}
+		iter.Close()
+		assert.Equal(t, keys, got)
+
+		for i := 0; i < 100; i++ {
+			seek := randomKey()
+			start := sort.SearchStrings(keys, string(seek))
+			iter := snap.index.Iterator(false)
+			iter.Seek(seek)
+			if start < len(keys) {
+				assert.True(t, iter.Valid())
+				assert.Equal(t, keys[start], string(iter.Key()))
+			} else {
+				assert.False(t, iter.Valid())
+			}
+			iter.Close()
+
+			// 反向定位到小于等于 seek 的最后一个 key
+			end := sort.Search(len(keys), func(i int) bool { return keys[i] > string(seek) })
+			iter = snap.index.Iterator(true)
+			iter.Seek(seek)
+			if end > 0 {
+				assert.True(t, iter.Valid())
+				assert.Equal(t, keys[end-1], string(iter.Key()))
+			} else {
+				assert.False(t, iter.Valid())
+			}
+			iter.Close()
+		}
+		assert.Nil(t, snap.index.Close())
+	}
+}
+
+// 长度超过 64 字节、有很长公共前缀的 key
+func benchmarkLongKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("tenant/%s/user/%08d", bytes.Repeat([]byte("x"), 80), i*7))
+	}
+	return keys
+}
+
+func BenchmarkAdaptiveRadixTree_Seek(b *testing.B) {
+	keys := benchmarkLongKeys(100000)
+	art := NewART()
+	for i, key := range keys {
+		art.Put(key, &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	r := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		iter := art.Iterator(i%2 == 1)
+		// 定位到不存在的 key，之后取 10 个
+		seek := append(append([]byte{}, keys[r.Intn(len(keys))]...), '0')
+		iter.Seek(seek)
+		for j := 0; j < 10 && iter.Valid(); j++ {
+			iter.Next()
+		}
+		iter.Close()
+	}
+}
+
+func BenchmarkAdaptiveRadixTree_PutWithIterators(b *testing.B) {
+	keys := benchmarkLongKeys(100000)
+	art := NewART()
+	for i, key := range keys {
+		art.Put(key, &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+	}
+	// 没有关闭的迭代器不影响写入的开销
+	for i := 0; i < 100; i++ {
+		art.Iterator(false)
+	}
+
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		art.Put(keys[i%len(keys)], &data.LogRecordPos{Fid: 2, Offset: uint64(i)})
+	}
+}
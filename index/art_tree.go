@@ -0,0 +1,373 @@
+package index
+
+import (
+	"bytes"
+	"sort"
+
+	"bitcask-go/data"
+)
+
+// 子节点不超过这个数量时用有序数组保存，超过之后改为 256 个位置的数组按照字节直接定位
+const artSmallNodeSize = 48
+
+// 写时复制的标记，节点只能被创建它的树直接修改，和其他树共享的节点修改之前需要先复制
+type artCow struct {
+	_ byte // 保证每次 new 得到不同的指针
+}
+
+// 支持写时复制的自适应基数树，克隆只复制根节点的引用，之后两棵树各自修改时复制经过的路径
+type artTree struct {
+	root *artNode
+	size int
+	cow  *artCow
+}
+
+// 基数树的节点，从父节点经过一个字节到达这个节点，再经过 prefix 中的字节，得到这个节点的路径
+type artNode struct {
+	cow      *artCow
+	prefix   []byte         // 压缩的路径，不会被原地修改
+	leaf     *Item          // key 正好等于这个节点的路径的数据
+	keys     []byte         // 子节点较少时，每个子节点对应的字节，从小到大排列
+	children []*artNode     // 和 keys 一一对应
+	node256  *[256]*artNode // 子节点较多时按照字节保存
+	num      int            // node256 中子节点的数量
+}
+
+func newArtTree() *artTree {
+	return &artTree{cow: new(artCow)}
+}
+
+// 克隆一棵树，克隆之后两棵树都不能直接修改现有的节点
+func (t *artTree) clone() *artTree {
+	out := *t
+	t.cow, out.cow = new(artCow), new(artCow)
+	return &out
+}
+
+// 返回可以直接修改的节点，和其他树共享的节点复制一份
+func (t *artTree) mutable(n *artNode) *artNode {
+	if n.cow == t.cow {
+		return n
+	}
+	out := &artNode{
+		cow:    t.cow,
+		prefix: n.prefix,
+		leaf:   n.leaf,
+		num:    n.num,
+	}
+	if n.node256 != nil {
+		node256 := *n.node256
+		out.node256 = &node256
+	} else {
+		out.keys = append([]byte(nil), n.keys...)
+		out.children = append([]*artNode(nil), n.children...)
+	}
+	return out
+}
+
+func (t *artTree) get(key []byte) *Item {
+	n, depth := t.root, 0
+	for n != nil {
+		if !bytes.HasPrefix(key[depth:], n.prefix) {
+			return nil
+		}
+		depth += len(n.prefix)
+		if depth == len(key) {
+			return n.leaf
+		}
+		n, depth = n.child(key[depth]), depth+1
+	}
+	return nil
+}
+
+func (t *artTree) put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	var old *Item
+	t.root, old = t.insert(t.root, &Item{key: key, pos: pos}, 0)
+	if old == nil {
+		t.size++
+		return nil
+	}
+	return old.pos
+}
+
+func (t *artTree) insert(n *artNode, item *Item, depth int) (*artNode, *Item) {
+	key := item.key
+	if n == nil {
+		return &artNode{cow: t.cow, prefix: key[depth:], leaf: item}, nil
+	}
+
+	// key 和压缩的路径在中间分叉，在分叉的位置拆分出新的节点
+	l := commonPrefixLen(n.prefix, key[depth:])
+	if l < len(n.prefix) {
+		parent := &artNode{cow: t.cow, prefix: n.prefix[:l]}
+		child := t.mutable(n)
+		b := child.prefix[l]
+		child.prefix = child.prefix[l+1:]
+		parent.setChild(b, child)
+		if depth+l == len(key) {
+			parent.leaf = item
+		} else {
+			parent.setChild(key[depth+l], &artNode{cow: t.cow, prefix: key[depth+l+1:], leaf: item})
+		}
+		return parent, nil
+	}
+
+	depth += len(n.prefix)
+	n = t.mutable(n)
+	if depth == len(key) {
+		old := n.leaf
+		n.leaf = item
+		return n, old
+	}
+	b := key[depth]
+	child, old := t.insert(n.child(b), item, depth+1)
+	n.setChild(b, child)
+	return n, old
+}
+
+func (t *artTree) delete(key []byte) (*data.LogRecordPos, bool) {
+	// 先确认 key 存在，不存在时不复制任何节点
+	if t.get(key) == nil {
+		return nil, false
+	}
+	var old *Item
+	t.root, old = t.remove(t.root, key, 0)
+	t.size--
+	return old.pos, true
+}
+
+// 删除一定存在的 key，返回替换 n 的节点
+func (t *artTree) remove(n *artNode, key []byte, depth int) (*artNode, *Item) {
+	depth += len(n.prefix)
+	n = t.mutable(n)
+	var old *Item
+	if depth == len(key) {
+		old, n.leaf = n.leaf, nil
+	} else {
+		b := key[depth]
+		var child *artNode
+		if child, old = t.remove(n.child(b), key, depth+1); child == nil {
+			n.removeChild(b)
+		} else {
+			n.setChild(b, child)
+		}
+	}
+	return t.compact(n), old
+}
+
+// 没有数据也没有子节点的节点删除，没有数据并且只有一个子节点的节点和子节点合并
+func (t *artTree) compact(n *artNode) *artNode {
+	if n.leaf != nil {
+		return n
+	}
+	switch n.childNum() {
+	case 0:
+		return nil
+	case 1:
+		b, child := n.onlyChild()
+		child = t.mutable(child)
+		prefix := make([]byte, 0, len(n.prefix)+1+len(child.prefix))
+		prefix = append(append(append(prefix, n.prefix...), b), child.prefix...)
+		child.prefix = prefix
+		return child
+	}
+	return n
+}
+
+// 按照遍历方向从 from 开始遍历（inclusive 为 false 时不包括 from），from 为 nil 时遍历所有数据，fn 返回 false 时停止
+func (t *artTree) walk(from []byte, inclusive, reverse bool, fn func(item *Item) bool) {
+	if t.root == nil {
+		return
+	}
+	if from == nil {
+		t.root.walkAll(reverse, fn)
+		return
+	}
+	t.root.walkFrom(0, from, inclusive, reverse, fn)
+}
+
+// 从 from 开始遍历子树，depth 之前的路径和 from 相同，返回是否还需要继续
+func (n *artNode) walkFrom(depth int, from []byte, inclusive, reverse bool, fn func(item *Item) bool) bool {
+	rest := from[depth:]
+	if len(rest) <= len(n.prefix) || !bytes.Equal(n.prefix, rest[:len(n.prefix)]) {
+		l := len(n.prefix)
+		if len(rest) < l {
+			l = len(rest)
+		}
+		cmp := bytes.Compare(n.prefix[:l], rest[:l])
+
+		// 路径等于 from，子节点都比 from 大
+		if cmp == 0 && len(rest) == len(n.prefix) {
+			if reverse {
+				return !inclusive || n.leaf == nil || fn(n.leaf)
+			}
+			if inclusive && n.leaf != nil && !fn(n.leaf) {
+				return false
+			}
+			return n.walkChildren(0, 0xff, false, fn)
+		}
+
+		// 路径和 from 在中间分叉，或者 from 是路径的前缀，整个子树都在 from 的同一侧
+		if greater := cmp >= 0; greater != reverse {
+			return n.walkAll(reverse, fn)
+		}
+		return true
+	}
+
+	// 路径是 from 的前缀，这个节点的数据比 from 小，按照下一个字节区分子节点
+	depth += len(n.prefix)
+	b := int(from[depth])
+	if !reverse {
+		if child := n.child(byte(b)); child != nil && !child.walkFrom(depth+1, from, inclusive, false, fn) {
+			return false
+		}
+		return b == 0xff || n.walkChildren(b+1, 0xff, false, fn)
+	}
+	if child := n.child(byte(b)); child != nil && !child.walkFrom(depth+1, from, inclusive, true, fn) {
+		return false
+	}
+	if b > 0 && !n.walkChildren(0, b-1, true, fn) {
+		return false
+	}
+	return n.leaf == nil || fn(n.leaf)
+}
+
+// 遍历子树中的所有数据，节点自己的数据比子节点中的都小
+func (n *artNode) walkAll(reverse bool, fn func(item *Item) bool) bool {
+	if !reverse && n.leaf != nil && !fn(n.leaf) {
+		return false
+	}
+	if !n.walkChildren(0, 0xff, reverse, fn) {
+		return false
+	}
+	return !reverse || n.leaf == nil || fn(n.leaf)
+}
+
+// 遍历字节在 [lo, hi] 之间的子节点
+func (n *artNode) walkChildren(lo, hi int, reverse bool, fn func(item *Item) bool) bool {
+	if n.node256 != nil {
+		for i := lo; i <= hi; i++ {
+			b := i
+			if reverse {
+				b = lo + hi - i
+			}
+			if child := n.node256[b]; child != nil && !child.walkAll(reverse, fn) {
+				return false
+			}
+		}
+		return true
+	}
+
+	start := sort.Search(len(n.keys), func(i int) bool { return int(n.keys[i]) >= lo })
+	end := sort.Search(len(n.keys), func(i int) bool { return int(n.keys[i]) > hi })
+	for i := start; i < end; i++ {
+		j := i
+		if reverse {
+			j = start + end - 1 - i
+		}
+		if !n.children[j].walkAll(reverse, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *artNode) child(b byte) *artNode {
+	if n.node256 != nil {
+		return n.node256[b]
+	}
+	if i := n.search(b); i < len(n.keys) && n.keys[i] == b {
+		return n.children[i]
+	}
+	return nil
+}
+
+// keys 中第一个大于等于 b 的位置
+func (n *artNode) search(b byte) int {
+	return sort.Search(len(n.keys), func(i int) bool { return n.keys[i] >= b })
+}
+
+func (n *artNode) childNum() int {
+	if n.node256 != nil {
+		return n.num
+	}
+	return len(n.keys)
+}
+
+func (n *artNode) onlyChild() (byte, *artNode) {
+	if n.node256 != nil {
+		for b, child := range n.node256 {
+			if child != nil {
+				return byte(b), child
+			}
+		}
+	}
+	return n.keys[0], n.children[0]
+}
+
+// 在访问此方法前节点必须可以直接修改
+func (n *artNode) setChild(b byte, child *artNode) {
+	if n.node256 != nil {
+		if n.node256[b] == nil {
+			n.num++
+		}
+		n.node256[b] = child
+		return
+	}
+
+	i := n.search(b)
+	if i < len(n.keys) && n.keys[i] == b {
+		n.children[i] = child
+		return
+	}
+	if len(n.keys) == artSmallNodeSize {
+		node256 := new([256]*artNode)
+		for j, key := range n.keys {
+			node256[key] = n.children[j]
+		}
+		node256[b] = child
+		n.node256, n.num = node256, len(n.keys)+1
+		n.keys, n.children = nil, nil
+		return
+	}
+	n.keys = append(n.keys, 0)
+	copy(n.keys[i+1:], n.keys[i:])
+	n.keys[i] = b
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+// 在访问此方法前节点必须可以直接修改
+func (n *artNode) removeChild(b byte) {
+	if n.node256 != nil {
+		if n.node256[b] != nil {
+			n.node256[b] = nil
+			n.num--
+		}
+		// 子节点减少到一半之后改回有序数组，避免在边界上反复转换
+		if n.num <= artSmallNodeSize/2 {
+			for key, child := range n.node256 {
+				if child != nil {
+					n.keys = append(n.keys, byte(key))
+					n.children = append(n.children, child)
+				}
+			}
+			n.node256, n.num = nil, 0
+		}
+		return
+	}
+
+	if i := n.search(b); i < len(n.keys) && n.keys[i] == b {
+		n.keys = append(n.keys[:i], n.keys[i+1:]...)
+		n.children = append(n.children[:i], n.children[i+1:]...)
+	}
+}
+
+func commonPrefixLen(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
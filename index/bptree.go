@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bytes"
 	"path/filepath"
 
 	"go.etcd.io/bbolt"
@@ -8,7 +9,10 @@ import (
 	"bitcask-go/data"
 )
 
-const bptreeIndexFileName = "bptree-index"
+const BPTreeIndexFileName = "bptree-index"
+
+// 索引文件 mmap 的初始大小，只占用地址空间，不占用内存
+const bptreeInitialMmapSize = 1 << 30
 
 var indexBucketname = []byte("bitcask-index")
 
@@ -24,7 +28,9 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	// opts include many customed settings
 	opts := bbolt.DefaultOptions
 	opts.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opts)
+	// 只读事务期间写事务无法重新 mmap，预留足够大的地址空间，快照和迭代器打开时写入不会被阻塞
+	opts.InitialMmapSize = bptreeInitialMmapSize
+	bptree, err := bbolt.Open(filepath.Join(dirPath, BPTreeIndexFileName), 0644, opts)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -113,9 +119,68 @@ func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
 	return newBptreeIterator(bpt.tree, reverse)
 }
 
+// Snapshot 持有 bbolt 的只读事务作为快照，事务期间看到的索引不再变化
+// 索引文件超过 bptreeInitialMmapSize 之后，只读事务会阻塞写事务对文件的扩容，快照使用完之后需要尽快 Close
+func (bpt *BPlusTree) Snapshot() (Indexer, error) {
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &bptreeSnapshot{tx: tx, bucket: tx.Bucket(indexBucketname)}, nil
+}
+
+// Begin 开启只读事务，事务期间看到的索引文件不再变化，用于备份时拷贝出一致的索引文件
+// 长时间的只读事务会阻塞写事务对文件的扩容，使用完之后需要尽快 Rollback
+func (bpt *BPlusTree) Begin() (*bbolt.Tx, error) {
+	return bpt.tree.Begin(false)
+}
+
+// B+ 树索引的快照，bucket 在创建时获取，之后只读取不修改，可以被并发读取
+type bptreeSnapshot struct {
+	tx     *bbolt.Tx
+	bucket *bbolt.Bucket
+}
+
+// Put 快照是只读的
+func (snap *bptreeSnapshot) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	panic("bptree snapshot is read-only")
+}
+
+func (snap *bptreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	value := snap.bucket.Get(key)
+	if len(value) == 0 {
+		return nil
+	}
+	return data.DecodeLogRecordPos(value)
+}
+
+// Delete 快照是只读的
+func (snap *bptreeSnapshot) Delete(key []byte) (*data.LogRecordPos, bool) {
+	panic("bptree snapshot is read-only")
+}
+
+func (snap *bptreeSnapshot) Iterator(reverse bool) Iterator {
+	bpti := &bptreeIterator{cursor: snap.bucket.Cursor(), reverse: reverse}
+	bpti.Rewind()
+	return bpti
+}
+
+func (snap *bptreeSnapshot) Size() int {
+	return snap.bucket.Stats().KeyN
+}
+
+func (snap *bptreeSnapshot) Snapshot() (Indexer, error) {
+	return snap, nil
+}
+
+// Close 结束只读事务，之后快照和它的迭代器都不能再使用
+func (snap *bptreeSnapshot) Close() error {
+	return snap.tx.Rollback()
+}
+
 // B+ 树迭代器
 type bptreeIterator struct {
-	tx        *bbolt.Tx
+	tx        *bbolt.Tx // 迭代器自己开启的只读事务，快照上的迭代器使用快照的事务，为 nil
 	cursor    *bbolt.Cursor
 	reverse   bool
 	currKey   []byte
@@ -148,6 +213,15 @@ func (bpti *bptreeIterator) Rewind() {
 
 func (bpti *bptreeIterator) Seek(key []byte) {
 	bpti.currKey, bpti.currValue = bpti.cursor.Seek(key)
+	if !bpti.reverse {
+		return
+	}
+	// 反向遍历时定位到小于等于 key 的最后一个 key
+	if bpti.currKey == nil {
+		bpti.currKey, bpti.currValue = bpti.cursor.Last()
+	} else if bytes.Compare(bpti.currKey, key) > 0 {
+		bpti.currKey, bpti.currValue = bpti.cursor.Prev()
+	}
 }
 
 func (bpti *bptreeIterator) Next() {
@@ -171,5 +245,7 @@ func (bpti *bptreeIterator) Value() *data.LogRecordPos {
 }
 
 func (bpti *bptreeIterator) Close() {
-	_ = bpti.tx.Rollback()
+	if bpti.tx != nil {
+		_ = bpti.tx.Rollback()
+	}
 }
@@ -117,3 +117,43 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 1, Offset: 24})
+
+	snap, err := tree.Snapshot()
+	assert.Nil(t, err)
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 2, Offset: 36})
+	tree.Delete([]byte("abc"))
+	tree.Put([]byte("acc"), &data.LogRecordPos{Fid: 2, Offset: 48})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, uint32(1), snap.Get([]byte("aac")).Fid)
+	assert.NotNil(t, snap.Get([]byte("abc")))
+	assert.Nil(t, snap.Get([]byte("acc")))
+
+	// 快照上的迭代器看到的同样是创建快照时刻的数据
+	var keys []string
+	iter := snap.Iterator(true)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, string(iter.Key()))
+	}
+	iter.Close()
+	assert.Equal(t, []string{"abc", "aac"}, keys)
+
+	assert.Nil(t, snap.Close())
+	assert.Nil(t, tree.Close())
+
+	// 索引文件已经关闭时返回错误
+	snap, err = tree.Snapshot()
+	assert.NotNil(t, err)
+	assert.Nil(t, snap)
+}
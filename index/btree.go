@@ -2,7 +2,6 @@ package index
 
 import (
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -73,73 +72,61 @@ func (bt *BTree) Close() error {
 	return nil
 }
 
+// Snapshot 基于 btree 的写时复制，克隆的开销很小
+func (bt *BTree) Snapshot() (Indexer, error) {
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}, nil
+}
+
 func (bt *BTree) Iterator(reverse bool) Iterator {
 	if bt.tree == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return newBTreeIterator(bt.tree, reverse)
+	// 克隆需要修改原来的树的写时复制标记，需要持有写锁
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return newBTreeIterator(bt.tree.Clone(), reverse)
 }
 
 // BTree 索引迭代器
+// 遍历创建时克隆的树，每次从树中取出一批数据，内存占用和实际遍历的数据量相关
 type btreeIterator struct {
-	currIndex int     // 当前遍历的下标位置
-	reverse   bool    // 是否反向遍历
-	values    []*Item // key+位置索引信息
+	tree    *btree.BTree  // 创建迭代器时克隆的树，之后对索引的修改对其不可见
+	reverse bool          // 是否反向遍历
+	batch   iteratorBatch // 当前取出的一批数据
 }
 
 func newBTreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	// 潜在问题，可能导致内存突然膨胀
-	values := make([]*Item, tree.Len())
-
-	saveVaules := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-	if reverse {
-		tree.Descend(saveVaules)
-	} else {
-		tree.Ascend(saveVaules)
+	bti := &btreeIterator{
+		tree:    tree,
+		reverse: reverse,
 	}
-
-	return &btreeIterator{
-		currIndex: 0,
-		reverse:   reverse,
-		values:    values,
-	}
-
+	bti.Rewind()
+	return bti
 }
 
 // Rewind 重新回到迭代器的起点，第一个数据
 func (bti *btreeIterator) Rewind() {
-	bti.currIndex = 0
+	bti.batch.rewind(bti.load)
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据这个 key 开始遍历
 func (bti *btreeIterator) Seek(key []byte) {
-	if bti.reverse {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
-	} else {
-		bti.currIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
-	}
-
+	bti.batch.seek(key, bti.load)
 }
 
 // Next 跳转到下一个 key
 func (bti *btreeIterator) Next() {
-	bti.currIndex += 1
+	bti.batch.next(bti.load)
 }
 
 // Valid 是否有效
 func (bti *btreeIterator) Valid() bool {
-	return bti.currIndex < len(bti.values)
+	return bti.batch.valid()
 }
 
 // Key 当前遍历位置的 key 的数据
@@ -147,15 +134,49 @@ func (bti *btreeIterator) Key() []byte {
 	if !bti.Valid() {
 		panic("iterator out of bound")
 	}
-	return bti.values[bti.currIndex].key
+	return bti.batch.item().key
 }
 
 // Value 当前遍历位置的 value
 func (bti *btreeIterator) Value() *data.LogRecordPos {
-	return bti.values[bti.currIndex].pos
+	return bti.batch.item().pos
 }
 
 // Close 关闭迭代器，释放对应的资源
 func (bti *btreeIterator) Close() {
-	bti.values = nil
+	bti.tree = nil
+	bti.batch = iteratorBatch{}
+}
+
+// 从 from 开始（inclusive 为 false 时不包括 from）按照遍历方向取出最多 limit 条数据
+func (bti *btreeIterator) load(from []byte, inclusive bool, limit int) []*Item {
+	if bti.tree == nil {
+		return nil
+	}
+	items := make([]*Item, 0, limit)
+	skipFrom := !inclusive
+	collect := func(it btree.Item) bool {
+		item := it.(*Item)
+		// 只有第一条数据可能等于 from
+		if skipFrom {
+			skipFrom = false
+			if bytes.Equal(item.key, from) {
+				return true
+			}
+		}
+		items = append(items, item)
+		return len(items) < limit
+	}
+
+	switch {
+	case from == nil && bti.reverse:
+		bti.tree.Descend(collect)
+	case from == nil:
+		bti.tree.Ascend(collect)
+	case bti.reverse:
+		bti.tree.DescendLessOrEqual(&Item{key: from}, collect)
+	default:
+		bti.tree.AscendGreaterOrEqual(&Item{key: from}, collect)
+	}
+	return items
 }
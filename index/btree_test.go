@@ -102,3 +102,22 @@ func TestBTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter6.Key())
 	}
 }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBTree()
+	bt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	bt.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 24})
+
+	snap, err := bt.Snapshot()
+	assert.Nil(t, err)
+	bt.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 36})
+	bt.Delete([]byte("key-2"))
+	bt.Put([]byte("key-3"), &data.LogRecordPos{Fid: 2, Offset: 48})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, uint32(1), snap.Get([]byte("key-1")).Fid)
+	assert.NotNil(t, snap.Get([]byte("key-2")))
+	assert.Nil(t, snap.Get([]byte("key-3")))
+	assert.Equal(t, uint32(2), bt.Get([]byte("key-1")).Fid)
+	assert.Nil(t, snap.Close())
+}
@@ -25,6 +25,9 @@ type Indexer interface {
 	// 索引中的数据量
 	Size() int
 
+	// Snapshot 返回当前时刻索引的只读视图，后续对索引的修改对其不可见，使用完之后需要 Close
+	Snapshot() (Indexer, error)
+
 	// 关闭索引
 	Close() error
 }
@@ -86,6 +89,53 @@ type Iterator interface {
 	// Value 当前遍历位置的 value
 	Value() *data.LogRecordPos
 
-	// Close 关闭迭代器，释放对应的资源
+	// Close 关闭迭代器，释放对应的资源，迭代器使用完之后必须关闭
 	Close()
 }
+
+// 迭代器每次从索引中取出的数据量
+const iteratorBatchSize = 128
+
+// 从 from 开始（inclusive 为 false 时不包括 from）按照遍历方向取出最多 limit 条数据，from 为 nil 时从头开始
+// 返回的数据少于 limit 条表示已经没有更多的数据
+type iteratorLoader func(from []byte, inclusive bool, limit int) []*Item
+
+// 分批从索引中加载数据的迭代器，只保存当前的一批数据
+type iteratorBatch struct {
+	items []*Item
+	idx   int
+	done  bool // 当前这批之后已经没有数据
+}
+
+func (b *iteratorBatch) rewind(load iteratorLoader) {
+	b.fill(nil, true, load)
+}
+
+func (b *iteratorBatch) seek(key []byte, load iteratorLoader) {
+	// nil 表示从头开始，seek 时需要和空 key 区分
+	if key == nil {
+		key = []byte{}
+	}
+	b.fill(key, true, load)
+}
+
+func (b *iteratorBatch) next(load iteratorLoader) {
+	b.idx++
+	if b.idx >= len(b.items) && !b.done {
+		b.fill(b.items[len(b.items)-1].key, false, load)
+	}
+}
+
+func (b *iteratorBatch) fill(from []byte, inclusive bool, load iteratorLoader) {
+	b.items = load(from, inclusive, iteratorBatchSize)
+	b.idx = 0
+	b.done = len(b.items) < iteratorBatchSize
+}
+
+func (b *iteratorBatch) valid() bool {
+	return b.idx < len(b.items)
+}
+
+func (b *iteratorBatch) item() *Item {
+	return b.items[b.idx]
+}
@@ -0,0 +1,154 @@
+package index
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+)
+
+func TestIterator_Order(t *testing.T) {
+	for name, newIndexer := range map[string]func() Indexer{
+		"btree": func() Indexer { return NewBTree() },
+		"art":   func() Indexer { return NewART() },
+		"bptree": func() Indexer {
+			dir, _ := os.MkdirTemp("", "bptree-iter-order")
+			return NewBPlusTree(dir, false)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// 字符集较小，key 之间有很多公共前缀，也有互为前缀的 key
+			r := rand.New(rand.NewSource(1))
+			alphabet := []byte{0x00, 'a', 'b', 0xff}
+			randomKey := func() []byte {
+				key := make([]byte, 1+r.Intn(6))
+				for i := range key {
+					key[i] = alphabet[r.Intn(len(alphabet))]
+				}
+				return key
+			}
+
+			idx := newIndexer()
+			defer func() {
+				if bpt, ok := idx.(*BPlusTree); ok {
+					_ = os.RemoveAll(filepath.Dir(bpt.tree.Path()))
+				}
+				_ = idx.Close()
+			}()
+			positions := make(map[string]uint64)
+			for i := 0; i < 2000; i++ {
+				key := randomKey()
+				idx.Put(key, &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+				positions[string(key)] = uint64(i)
+			}
+			var keys []string
+			for key := range positions {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
+			collect := func(iter Iterator, limit int) []string {
+				var result []string
+				for ; iter.Valid() && len(result) < limit; iter.Next() {
+					assert.Equal(t, positions[string(iter.Key())], iter.Value().Offset)
+					result = append(result, string(iter.Key()))
+				}
+				return result
+			}
+			reversed := make([]string, len(keys))
+			for i, key := range keys {
+				reversed[len(keys)-1-i] = key
+			}
+
+			iter := idx.Iterator(false)
+			assert.Equal(t, keys, collect(iter, len(keys)))
+			iter.Rewind()
+			assert.Equal(t, keys, collect(iter, len(keys)))
+			iter.Close()
+			iter = idx.Iterator(true)
+			assert.Equal(t, reversed, collect(iter, len(keys)))
+			iter.Close()
+
+			// seek 到存在和不存在的 key
+			for i := 0; i < 200; i++ {
+				seek := randomKey()
+				start := sort.SearchStrings(keys, string(seek))
+				iter := idx.Iterator(false)
+				iter.Seek(seek)
+				assert.Equal(t, keys[start:], collect(iter, len(keys)))
+				iter.Close()
+
+				start = sort.Search(len(reversed), func(i int) bool {
+					return bytes.Compare([]byte(reversed[i]), seek) <= 0
+				})
+				iter = idx.Iterator(true)
+				iter.Seek(seek)
+				assert.Equal(t, reversed[start:], collect(iter, len(keys)))
+				iter.Close()
+			}
+		})
+	}
+}
+
+func TestIterator_Consistent(t *testing.T) {
+	for name, newIndexer := range map[string]func() Indexer{
+		"btree": func() Indexer { return NewBTree() },
+		"art":   func() Indexer { return NewART() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			idx := newIndexer()
+			for i := 0; i < 1000; i += 2 {
+				idx.Put([]byte{byte(i >> 8), byte(i)}, &data.LogRecordPos{Fid: 1, Offset: uint64(i)})
+			}
+
+			// 创建之后的写入、覆盖和删除对迭代器不可见
+			iter := idx.Iterator(false)
+			reverseIter := idx.Iterator(true)
+			for i := 0; i < 1000; i++ {
+				key := []byte{byte(i >> 8), byte(i)}
+				switch i % 4 {
+				case 0:
+					idx.Delete(key)
+				case 1:
+					idx.Put(key, &data.LogRecordPos{Fid: 2, Offset: uint64(i)})
+				case 2:
+					idx.Put(key, &data.LogRecordPos{Fid: 2, Offset: uint64(i)})
+				}
+			}
+
+			var i int
+			for ; iter.Valid(); iter.Next() {
+				assert.Equal(t, []byte{byte(i >> 8), byte(i)}, iter.Key())
+				assert.Equal(t, uint32(1), iter.Value().Fid)
+				assert.Equal(t, uint64(i), iter.Value().Offset)
+				i += 2
+			}
+			assert.Equal(t, 1000, i)
+			iter.Close()
+
+			for i = 998; reverseIter.Valid(); reverseIter.Next() {
+				assert.Equal(t, []byte{byte(i >> 8), byte(i)}, reverseIter.Key())
+				assert.Equal(t, uint32(1), reverseIter.Value().Fid)
+				i -= 2
+			}
+			assert.Equal(t, -2, i)
+			reverseIter.Close()
+
+			// 新的迭代器可以看到修改之后的数据
+			iter = idx.Iterator(false)
+			var count int
+			for ; iter.Valid(); iter.Next() {
+				assert.Equal(t, uint32(2), iter.Value().Fid)
+				count++
+			}
+			assert.Equal(t, 500, count)
+			iter.Close()
+		})
+	}
+}
@@ -2,6 +2,7 @@ package bitcask_go
 
 import (
 	"bytes"
+	"time"
 
 	"bitcask-go/index"
 )
@@ -9,27 +10,49 @@ import (
 type Iterator struct {
 	indexIter index.Iterator // 索引迭代器
 	db        *DB
+	snapshot  *Snapshot // 不为空时从快照中读取数据
+	view      *fileView // 创建迭代器时的数据文件，merge 之后依然可以读取
 	options   IteratorOptions
+	lower     []byte // 遍历范围的下界（包括），为空表示没有下界
+	upper     []byte // 遍历范围的上界（不包括），为空表示没有上界
 }
 
 // NewItertor 初始化迭代器
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator{
+	// 持有锁保证索引和数据文件是一致的
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
 	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator {
+	iterator := &Iterator {
 		db : db,
 		indexIter: indexIter,
+		view: db.newFileView(),
 		options: opts,
 	}
+	iterator.start()
+	return iterator
 }
 
 // Rewind 重新回到迭代器的起点，第一个数据
 func (it *Iterator) Rewind() {
-	it.indexIter.Rewind()
+	if key := it.startKey(); key != nil {
+		it.indexIter.Seek(key)
+	} else {
+		it.indexIter.Rewind()
+	}
 	it.skipToNext()
 }
 
 // Seek 根据传入的 key 查找到第一个大于（或小于）等于的目标 key，根据这个 key 开始遍历
+// key 在遍历范围之前时从范围的起点开始
 func (it *Iterator) Seek(key []byte) {
+	if start := it.startKey(); start != nil {
+		cmp := bytes.Compare(key, start)
+		if (!it.options.Reverse && cmp < 0) || (it.options.Reverse && cmp > 0) {
+			key = start
+		}
+	}
 	it.indexIter.Seek(key)
 	it.skipToNext()
 }
@@ -42,7 +65,7 @@ func (it *Iterator) Next() {
 
 // Valid 是否有效
 func (it *Iterator) Valid() bool {
-	return it.indexIter.Valid()
+	return it.indexIter.Valid() && inRange(it.indexIter.Key(), it.lower, it.upper)
 }
 
 // Key 当前遍历位置的 key 的数据
@@ -53,27 +76,76 @@ func (it *Iterator) Key() []byte {
 // Value 当前遍历位置的 value
 func (it *Iterator) Value() ([]byte, error) {
 	logRecordPos := it.indexIter.Value()
-	it.db.mu.RLock()
-	defer it.db.mu.RUnlock()
-	return it.db.getValuesByPosition(logRecordPos)
+	if it.snapshot != nil {
+		return it.snapshot.getValuesByPosition(logRecordPos)
+	}
+	return it.view.getValuesByPosition(logRecordPos)
 }
 
 // Close 关闭迭代器，释放对应的资源
+// 迭代器使用完之后必须关闭，否则 merge 替换掉的文件不会被关闭，ART 索引也会一直为它记录被修改的 key
 func (it *Iterator) Close() {
 	it.indexIter.Close()
+	if it.view != nil {
+		it.db.releaseFileView(it.view)
+		it.view = nil
+	}
+}
+
+
+// 根据前缀和上下界计算遍历范围，并定位到范围的起点
+func (it *Iterator) start() {
+	it.lower, it.upper = it.options.LowerBound, it.options.UpperBound
+	if prefix := it.options.Prefix; len(prefix) > 0 {
+		if it.lower == nil || bytes.Compare(prefix, it.lower) > 0 {
+			it.lower = prefix
+		}
+		// 前缀全部是 0xff 时大于等于前缀的 key 都以前缀开头
+		if end := prefixEnd(prefix); end != nil && (len(it.upper) == 0 || bytes.Compare(end, it.upper) < 0) {
+			it.upper = end
+		}
+	}
+
+	// 索引迭代器创建之后已经位于起点
+	if key := it.startKey(); key != nil {
+		it.indexIter.Seek(key)
+	}
+	it.skipToNext()
 }
 
+// 遍历范围的起点，正向遍历是下界，反向遍历是上界，为空表示从头开始
+func (it *Iterator) startKey() []byte {
+	if it.options.Reverse {
+		if len(it.upper) == 0 {
+			return nil
+		}
+		return it.upper
+	}
+	if len(it.lower) == 0 {
+		return nil
+	}
+	return it.lower
+}
 
+// 跳过已经过期的 key，反向遍历时跳过定位到的上界
 func (it *Iterator) skipToNext() {
-	prefixLen := len(it.options.Prefix)
-	if prefixLen == 0 {
-		return 
+	now := time.Now().UnixNano()
+	if it.snapshot != nil {
+		now = it.snapshot.now
 	}
 
 	for ; it.indexIter.Valid(); it.indexIter.Next() {
 		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.options.Prefix, key[:prefixLen]) == 0 {
+		if it.options.Reverse && len(it.upper) > 0 && bytes.Compare(key, it.upper) >= 0 {
+			continue
+		}
+		// 超出遍历范围，Valid 返回 false
+		if !inRange(key, it.lower, it.upper) {
 			break
 		}
+		if it.indexIter.Value().IsExpired(now) {
+			continue
+		}
+		break
 	}
-}
\ No newline at end of file
+}
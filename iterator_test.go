@@ -99,3 +99,64 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 	}
 	iter3.Close()
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	for _, indexType := range []IndexerType{BTree, ART} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-iterator-bounds")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for _, key := range []string{"a", "b", "ba", "bb", "bc", "c", "d"} {
+			assert.Nil(t, db.Put([]byte(key), []byte(key)))
+		}
+
+		collect := func(opts IteratorOptions, seek []byte) []string {
+			iter := db.NewIterator(opts)
+			defer iter.Close()
+			if seek != nil {
+				iter.Seek(seek)
+			}
+			var keys []string
+			for ; iter.Valid(); iter.Next() {
+				keys = append(keys, string(iter.Key()))
+			}
+			return keys
+		}
+
+		// 前缀遍历，反向时从前缀中最后一个 key 开始
+		assert.Equal(t, []string{"b", "ba", "bb", "bc"}, collect(IteratorOptions{Prefix: []byte("b")}, nil))
+		assert.Equal(t, []string{"bc", "bb", "ba", "b"}, collect(IteratorOptions{Prefix: []byte("b"), Reverse: true}, nil))
+
+		// [LowerBound, UpperBound)
+		bounds := IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("c")}
+		assert.Equal(t, []string{"b", "ba", "bb", "bc"}, collect(bounds, nil))
+		bounds.Reverse = true
+		assert.Equal(t, []string{"bc", "bb", "ba", "b"}, collect(bounds, nil))
+		assert.Equal(t, []string{"ba", "b"}, collect(bounds, []byte("ba")))
+		assert.Equal(t, []string{"bc", "bb", "ba", "b"}, collect(bounds, []byte("z")))
+		bounds.Reverse = false
+		assert.Equal(t, []string{"b", "ba", "bb", "bc"}, collect(bounds, []byte("a")))
+		assert.Equal(t, []string{"bb", "bc"}, collect(bounds, []byte("bb")))
+		assert.Equal(t, 0, len(collect(bounds, []byte("c"))))
+
+		// 只有一个边界，以及和前缀组合
+		assert.Equal(t, []string{"c", "d"}, collect(IteratorOptions{LowerBound: []byte("bz")}, nil))
+		assert.Equal(t, []string{"ba", "b", "a"}, collect(IteratorOptions{UpperBound: []byte("bb"), Reverse: true}, nil))
+		assert.Equal(t, []string{"bb", "bc"}, collect(IteratorOptions{Prefix: []byte("b"), LowerBound: []byte("bb")}, nil))
+		assert.Equal(t, 0, len(collect(IteratorOptions{LowerBound: []byte("c"), UpperBound: []byte("b")}, nil)))
+
+		// Rewind 回到范围的起点
+		iter := db.NewIterator(IteratorOptions{LowerBound: []byte("bb"), Reverse: true})
+		iter.Seek([]byte("c"))
+		assert.Equal(t, []byte("c"), iter.Key())
+		iter.Rewind()
+		assert.Equal(t, []byte("d"), iter.Key())
+		iter.Close()
+
+		destroyDB(db)
+	}
+}
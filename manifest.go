@@ -0,0 +1,115 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"bitcask-go/index"
+)
+
+const (
+	manifestFileName = "MANIFEST"
+
+	// 当前的数据目录格式版本，修改磁盘上的格式时递增，并在 formatMigrations 中添加对应的升级方法
+	manifestFormatVersion = 1
+)
+
+// 数据目录的格式升级，formatMigrations[i] 将版本为 i+1 的数据目录升级到版本 i+2
+var formatMigrations []func(dirPath string) error
+
+// 创建数据目录时写入的 MANIFEST，记录数据目录的格式版本和创建之后不能修改的配置
+type manifest struct {
+	FormatVersion int         `json:"format_version"`
+	IndexType     IndexerType `json:"index_type"` // BTree 和 ART 都从数据文件构建索引，可以互相切换，B+ 树索引存储在磁盘上
+	Encrypted     bool        `json:"encrypted"`  // 开启加密之后旧的数据依然可以读取，加密之后的数据必须提供密钥
+}
+
+// 读取并校验数据目录的 MANIFEST，和配置项不兼容时返回错误
+// 数据目录是新创建的，或者是在引入 MANIFEST 之前创建的，写入新的 MANIFEST，可以兼容的配置修改和格式升级也会写回
+func checkManifest(options Options, isInitial bool) error {
+	m, err := readManifest(options.DirPath)
+	if os.IsNotExist(err) {
+		m, err = inferManifest(options, isInitial), nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.FormatVersion > manifestFormatVersion {
+		return ErrUnsupportedFormat
+	}
+	if (m.IndexType == BPlusTree) != (options.IndexType == BPlusTree) {
+		return ErrIndexTypeMismatch
+	}
+	if m.Encrypted && len(options.EncryptionKey) == 0 {
+		return ErrInvalidEncryptionKey
+	}
+
+	// 只读模式下不能修改数据目录，格式需要由写入的进程先升级
+	if options.ReadOnly {
+		if m.FormatVersion < manifestFormatVersion {
+			return ErrFormatUpgradeRequired
+		}
+		return nil
+	}
+
+	for m.FormatVersion < manifestFormatVersion {
+		if err := formatMigrations[m.FormatVersion-1](options.DirPath); err != nil {
+			return err
+		}
+		m.FormatVersion++
+	}
+	m.IndexType = options.IndexType
+	m.Encrypted = m.Encrypted || len(options.EncryptionKey) > 0
+	return writeManifest(options.DirPath, m)
+}
+
+// 没有 MANIFEST 时根据配置项和目录中的文件生成
+func inferManifest(options Options, isInitial bool) *manifest {
+	m := &manifest{
+		FormatVersion: manifestFormatVersion,
+		IndexType:     options.IndexType,
+		Encrypted:     len(options.EncryptionKey) > 0,
+	}
+	if isInitial {
+		return m
+	}
+
+	// 之前创建的数据目录，存在 B+ 树索引文件说明使用的是 B+ 树索引，否则使用的是内存索引
+	if _, err := os.Stat(filepath.Join(options.DirPath, index.BPTreeIndexFileName)); err == nil {
+		m.IndexType = BPlusTree
+	} else if options.IndexType == BPlusTree {
+		m.IndexType = BTree
+	}
+	return m
+}
+
+func readManifest(dirPath string) (*manifest, error) {
+	buf, err := os.ReadFile(filepath.Join(dirPath, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &manifest{}
+	if err := json.Unmarshal(buf, m); err != nil || m.FormatVersion <= 0 {
+		return nil, ErrManifestCorrupted
+	}
+	return m, nil
+}
+
+// 先写临时文件再重命名，保证 MANIFEST 要么是旧的，要么是完整的新的
+func writeManifest(dirPath string, m *manifest) error {
+	if old, err := readManifest(dirPath); err == nil && *old == *m {
+		return nil
+	}
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(dirPath, manifestFileName+".tmp")
+	if _, err := copyToFile(tmpPath, bytes.NewReader(buf), uint64(len(buf))); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(dirPath, manifestFileName))
+}
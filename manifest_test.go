@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_Manifest(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-manifest")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), utils.GetTestKey(1))
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 创建数据目录时写入 MANIFEST
+	m, err := readManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, manifestFormatVersion, m.FormatVersion)
+	assert.Equal(t, BTree, m.IndexType)
+	assert.False(t, m.Encrypted)
+
+	// 内存索引和 B+ 树索引不能互相切换
+	bptOpts := opts
+	bptOpts.IndexType = BPlusTree
+	_, err = Open(bptOpts)
+	assert.Equal(t, ErrIndexTypeMismatch, err)
+
+	// BTree 和 ART 可以互相切换
+	artOpts := opts
+	artOpts.IndexType = ART
+	db2, err := Open(artOpts)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	err = db2.Close()
+	assert.Nil(t, err)
+	m, err = readManifest(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, ART, m.IndexType)
+
+	// 之前创建的数据目录没有 MANIFEST，打开时补上，只读模式下不写入
+	err = os.Remove(filepath.Join(dir, manifestFileName))
+	assert.Nil(t, err)
+	roOpts := opts
+	roOpts.ReadOnly = true
+	db3, err := Open(roOpts)
+	assert.Nil(t, err)
+	err = db3.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, manifestFileName))
+	assert.True(t, os.IsNotExist(err))
+	_, err = Open(bptOpts)
+	assert.Equal(t, ErrIndexTypeMismatch, err)
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	err = db4.Close()
+	assert.Nil(t, err)
+	_, err = readManifest(dir)
+	assert.Nil(t, err)
+
+	// 更新的版本创建的数据目录
+	err = writeManifest(dir, &manifest{FormatVersion: manifestFormatVersion + 1, IndexType: BTree})
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, ErrUnsupportedFormat, err)
+
+	err = os.WriteFile(filepath.Join(dir, manifestFileName), []byte("{"), 0644)
+	assert.Nil(t, err)
+	_, err = Open(opts)
+	assert.Equal(t, ErrManifestCorrupted, err)
+	_ = os.RemoveAll(dir)
+}
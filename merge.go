@@ -7,24 +7,35 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
 )
 
 const (
 	mergeDirName     = "-merge"
 	mergeFinishedKey = "merge.finished"
+	mergeFileNumKey  = "merge.file.num"
 )
 
 // Merge 清理无效数据，生成 Hint 文件
+// merge 完成之后直接用新的数据文件替换旧的数据文件，迭代器和快照依然可以读取旧的文件，
+// 旧的文件在没有读者使用之后关闭
 func (db *DB) Merge() error {
-	// 如果数据库为空，直接返回
-	if db.activeFile == nil {
-		return nil
+	if db.options.ReadOnly {
+		return ErrReadOnly
 	}
 
 	db.mu.Lock()
+	// 如果数据库为空，直接返回，写入时会修改活跃文件，需要在持有锁之后判断
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
 	// 如果 merge 正在进行中，则直接返回
 	if db.isMerging {
 		db.mu.Unlock()
@@ -38,7 +49,12 @@ func (db *DB) Merge() error {
 		return err
 	}
 
-	if float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
+	// blob 文件不参与 merge，由 BlobGC 单独回收
+	totalSize -= db.blobFilesSize()
+
+	// 配置了旧密钥说明需要轮换密钥，此时不检查阈值，所有数据都会使用新的密钥重写
+	if len(db.options.PreviousEncryptionKeys) == 0 &&
+		float32(db.reclaimSize)/float32(totalSize) < db.options.DataFileMergeRatio {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
@@ -55,30 +71,39 @@ func (db *DB) Merge() error {
 		return ErrNoEnoughSpaceForMerge
 	}
 
+	// 上一次 merge 的结果还没有替换到数据目录中
+	if db.hasPendingMerge() {
+		db.mu.Unlock()
+		return ErrMergeNotApplied
+	}
+
 	db.isMerging = true
 	defer func() {
+		db.mu.Lock()
 		db.isMerging = false
+		db.mu.Unlock()
 	}()
+	start := time.Now()
 
-	// 持久化当前活跃文件
-	if err := db.activeFile.Sync(); err != nil {
-		return err
-	}
-
-	// 将当前活跃文件转换为旧的数据文件
-	db.olderFiles[db.activeFile.FileId] = db.activeFile
-	// 打开新的活跃文件
-	if err := db.setActiveDataFile(); err != nil {
+	// 将当前活跃文件转换为旧的数据文件，并打开新的活跃文件
+	if err := db.rotateActiveDataFile(); err != nil {
 		db.mu.Unlock()
-		return nil
+		return err
 	}
 	// 记录最近没有参与 merge 的文件 id
 	nonMergeFileId := db.activeFile.FileId
 
 	// 取出所有需要 merge 的文件
 	var mergeFiles []*data.DataFile
+	var mergeFilesSize uint64
 	for _, file := range db.olderFiles {
 		mergeFiles = append(mergeFiles, file)
+		size, err := file.IoManager.Size()
+		if err != nil {
+			db.mu.Unlock()
+			return err
+		}
+		mergeFilesSize += uint64(size)
 	}
 	db.mu.Unlock()
 
@@ -96,7 +121,7 @@ func (db *DB) Merge() error {
 	}
 
 	// 新建一个 merge path 的目录
-	if err := os.MkdirAll(mergePath, os.ModePerm); err == nil {
+	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
 
@@ -104,19 +129,31 @@ func (db *DB) Merge() error {
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false
+	// 只重写数据文件中的记录，不产生新的 blob 文件
+	mergeOptions.BlobThreshold = 0
+	mergeOptions.AutoMergeInterval = 0
+	mergeOptions.AutoMergeWrites = 0
 	mergeDB, err := Open(mergeOptions)
 
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if mergeDB != nil {
+			_ = mergeDB.Close()
+		}
+	}()
 
 	// 打开 hint 文件存储索引
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+	hintFile.Codec = db.codec
 
 	// 遍历处理每个数据文件
+	now := time.Now().UnixNano()
 	for _, dataFile := range mergeFiles {
 		var offset uint64 = 0
 		for {
@@ -127,15 +164,18 @@ func (db *DB) Merge() error {
 				}
 				return err
 			}
+			db.ioLimiter.Wait(int(size))
 
 			// 解析拿到实际的 key
 			realKey, _ := parseLogRecordKey(logRecord.Key)
 			logRecordPos := db.index.Get(realKey)
 
 			// 和内存中的索引位置（最新的）进行比较，如果有效则重写（说明就是最新的）
+			// 已经过期的数据不再重写
 			if logRecordPos != nil &&
 				logRecordPos.Fid == dataFile.FileId &&
-				logRecordPos.Offset == offset {
+				logRecordPos.Offset == offset &&
+				!logRecordPos.IsExpired(now) {
 
 				// 清除事务标记
 				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNum)
@@ -143,6 +183,7 @@ func (db *DB) Merge() error {
 				if err != nil {
 					return err
 				}
+				db.ioLimiter.Wait(int(pos.Size))
 
 				// 将当前位置索引写到 Hint 文件中
 				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
@@ -163,26 +204,140 @@ func (db *DB) Merge() error {
 		return err
 	}
 
+	// merge 产生的文件 id 从 0 开始，会替换掉同名的旧文件，所以数量不能超过旧的文件
+	var mergeFileNum uint32
+	if mergeDB.activeFile != nil {
+		mergeFileNum = mergeDB.activeFile.FileId + 1
+	}
+	if mergeFileNum > nonMergeFileId {
+		return ErrMergeFilesOverflow
+	}
+	if err := mergeDB.Close(); err != nil {
+		return err
+	}
+	mergeDB = nil
+
 	// 写标识 merge 完成的文件
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, fio.StandardFIO)
 	if err != nil {
 		return err
 	}
+	defer mergeFinishedFile.Close()
+	mergeFinishedFile.Codec = db.codec
 
 	// 每次 merge 需要标识哪些历史文件已经 merge，哪些没有 merge，每次将这个东西写进
-	// 一个新的 MergeFinishedFile 里
-	mergeFinRecord := &data.LogRecord{
-		Key:   []byte(mergeFinishedKey),
-		Value: []byte(strconv.Itoa(int(nonMergeFileId))),
+	// 一个新的 MergeFinishedFile 里，同时记录 merge 产生的文件数量，便于替换文件时判断哪些旧文件需要删除
+	mergeFinRecords := []*data.LogRecord{
+		{Key: []byte(mergeFinishedKey), Value: []byte(strconv.Itoa(int(nonMergeFileId)))},
+		{Key: []byte(mergeFileNumKey), Value: []byte(strconv.Itoa(int(mergeFileNum)))},
+	}
+	for _, record := range mergeFinRecords {
+		encRecord, _ := db.codec.EncodeLogRecord(record)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return err
+		}
 	}
-	encRecord, _ := data.EncodeLogRecord(mergeFinRecord)
-	if err := mergeFinishedFile.Write(encRecord); err != nil {
+	if err := mergeFinishedFile.Sync(); err != nil {
 		return err
 	}
-	if err := mergeFinishedFile.Sync(); err != nil {
+
+	// merge 产生的文件大小
+	var newFilesSize uint64
+	for fid := uint32(0); fid < mergeFileNum; fid++ {
+		info, err := os.Stat(data.GetDataFileName(mergePath, fid))
+		if err != nil {
+			return err
+		}
+		newFilesSize += uint64(info.Size())
+	}
+
+	if err := db.swapMergeFiles(nonMergeFileId, mergeFileNum, now); err != nil {
+		return err
+	}
+	db.recordMerge(start, mergeFilesSize, newFilesSize)
+	return nil
+}
+
+// 记录一次完成的 merge 的耗时和回收的磁盘空间
+func (db *DB) recordMerge(start time.Time, oldSize, newSize uint64) {
+	if oldSize > newSize {
+		atomic.AddUint64(&db.metrics.mergeReclaimedBytes, oldSize-newSize)
+	}
+	db.metrics.merge.since(start)
+}
+
+// 用 merge 产生的文件替换旧的数据文件，并将内存索引中指向旧文件的位置更新为新的位置
+func (db *DB) swapMergeFiles(nonMergeFileId, mergeFileNum uint32, mergeTime int64) error {
+	// 替换期间只读的进程不能加载文件
+	if err := db.lockSwap(); err != nil {
+		return err
+	}
+	defer db.unlockSwap()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.applyMergeFiles(); err != nil {
 		return err
 	}
 
+	// 之前的位置索引不再能和之后的比较，读过数据的事务提交时返回冲突
+	db.fileGeneration++
+
+	// 打开新的数据文件，替换掉旧的数据文件
+	var retired []*data.DataFile
+	for fid, file := range db.olderFiles {
+		if fid < nonMergeFileId {
+			retired = append(retired, file)
+			delete(db.olderFiles, fid)
+		}
+	}
+	for fid := uint32(0); fid < mergeFileNum; fid++ {
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Codec = db.codec
+		db.olderFiles[fid] = dataFile
+	}
+
+	// merge 时已经过期的数据没有被重写，直接从索引中删除
+	var expiredKeys [][]byte
+	iterator := db.index.Iterator(false)
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		pos := iterator.Value()
+		if pos.Fid < nonMergeFileId && pos.IsExpired(mergeTime) {
+			expiredKeys = append(expiredKeys, iterator.Key())
+		}
+	}
+	iterator.Close()
+	for _, key := range expiredKeys {
+		db.index.Delete(key)
+	}
+
+	// 旧文件中的无效数据已经被清理
+	for fid := range db.fileGarbage {
+		if fid < nonMergeFileId {
+			delete(db.fileGarbage, fid)
+		}
+	}
+
+	// 仍然指向旧文件的索引更新为 hint 文件中的新位置，merge 期间被修改过的 key 已经指向了新写入的文件，
+	// 它们在 merge 产生的文件中的数据是无效的
+	if err := db.walkHintFile(func(key []byte, pos *data.LogRecordPos) {
+		if oldPos := db.index.Get(key); oldPos != nil && oldPos.Fid < nonMergeFileId {
+			db.index.Put(key, pos)
+		} else {
+			db.fileGarbage[pos.Fid] += pos.Size
+		}
+	}); err != nil {
+		return err
+	}
+	db.resetReclaimSize()
+
+	db.nonMergeFileId = nonMergeFileId
+	db.compactedFileId, db.compactNum, db.compactedMergeFiles = 0, 0, nil
+	db.retireFiles(retired)
 	return nil
 }
 
@@ -194,52 +349,80 @@ func (db *DB) getMergePath() string {
 
 // 加载 merge 数据目录
 func (db *DB) logMergeFiles() error {
+	return db.applyMergeFiles()
+}
+
+// 打开时完成上次没有替换完的 merge 和 MergeFiles
+func (db *DB) applyPendingMerge() error {
+	if err := db.lockSwap(); err != nil {
+		return err
+	}
+	defer db.unlockSwap()
+
+	if err := db.logMergeFiles(); err != nil {
+		return err
+	}
+	return db.applyCompactFiles()
+}
+
+// 将 merge 目录中的文件移动到数据目录中，替换掉旧的数据文件
+// 标识 merge 完成的文件最后移动，中途失败之后再次执行可以继续完成替换
+func (db *DB) applyMergeFiles() error {
 	mergePath := db.getMergePath()
 	// merge 目录不存在的话直接返回
 	if _, err := os.Stat(mergePath); os.IsNotExist(err) {
 		return nil
 	}
-	defer func() {
-		_ = os.RemoveAll(mergePath)
-	}()
 
-	dirEntries, err := os.ReadDir(mergePath)
+	// 没有 merge 完成就删除 merge 目录
+	if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); os.IsNotExist(err) {
+		return os.RemoveAll(mergePath)
+	}
+
+	nonMergeFileId, mergeFileNum, err := db.readMergeFinishedFile(mergePath)
 	if err != nil {
 		return err
 	}
 
-	// 查找标识 merge 完成的文件，判断 merge 是否处理完了
-	var mergeFinished bool
-	var mergeFileNames []string
+	dirEntries, err := os.ReadDir(mergePath)
+	if err != nil {
+		return err
+	}
+	var mergeFileIds []int
 	for _, entry := range dirEntries {
-		if entry.Name() == data.MergeFinishedFileName {
-			mergeFinished = true
-		}
-
-		if entry.Name() == data.SeqNumFileName {
-			continue
-		}
-
-		if entry.Name() == fileLockName {
-			continue
+		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) {
+			fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), data.DataFileNameSuffix))
+			if err != nil {
+				return ErrDataDirectoryCorrupted
+			}
+			mergeFileIds = append(mergeFileIds, fileId)
 		}
+	}
+	sort.Ints(mergeFileIds)
 
-		mergeFileNames = append(mergeFileNames, entry.Name())
+	// 旧的版本中没有记录 merge 产生的文件数量，所有的文件都还在 merge 目录中
+	if mergeFileNum < 0 {
+		mergeFileNum = len(mergeFileIds)
 	}
 
-	// 没有 merge 完成就返回
-	if !mergeFinished {
-		return nil
+	// 参与 merge 的文件的 hint 文件已经失效，先于数据文件删除，避免和替换之后的同名数据文件对应上
+	for fileId := uint32(0); fileId < nonMergeFileId; fileId++ {
+		if err := os.Remove(data.GetHintFileName(db.options.DirPath, fileId)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
 
-	nonMergeFileId, err := db.getNonMergeFileId(mergePath)
-	if err != nil {
-		return nil
+	// 将新的数据文件移动到数据目录中，替换同名的旧文件
+	for _, fileId := range mergeFileIds {
+		srcPath := data.GetDataFileName(mergePath, uint32(fileId))
+		destPath := data.GetDataFileName(db.options.DirPath, uint32(fileId))
+		if err := os.Rename(srcPath, destPath); err != nil {
+			return err
+		}
 	}
 
-	// 删除旧的数据文件, 即 /temp/bitcask-go 路径下的 .data
-	var fileId uint32 = 0
-	for ; fileId < nonMergeFileId; fileId++ {
+	// 删除没有被替换的旧数据文件, 即 /temp/bitcask-go 路径下的 .data
+	for fileId := uint32(mergeFileNum); fileId < nonMergeFileId; fileId++ {
 		fileName := data.GetDataFileName(db.options.DirPath, fileId)
 		if _, err := os.Stat(fileName); err == nil {
 			if err := os.Remove(fileName); err != nil {
@@ -248,37 +431,102 @@ func (db *DB) logMergeFiles() error {
 		}
 	}
 
-	// 将新的数据文件移动到数据目录中
-	for _, fileName := range mergeFileNames {
+	// MergeFiles 重写的文件都已经被替换
+	if err := os.Remove(filepath.Join(db.options.DirPath, data.CompactFinishedFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// 最后移动 hint 文件和标识 merge 完成的文件
+	for _, fileName := range []string{data.HintFileName, data.MergeFinishedFileName} {
 		srcPath := filepath.Join(mergePath, fileName)
-		destPath := filepath.Join(db.options.DirPath, fileName)
-		if err := os.Rename(srcPath, destPath); err != nil {
+		if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(srcPath, filepath.Join(db.options.DirPath, fileName)); err != nil {
 			return err
 		}
 	}
-	return nil
+	return os.RemoveAll(mergePath)
+}
+
+// 是否有已经完成但还没有替换到数据目录中的 merge 或者 MergeFiles
+func (db *DB) hasPendingMerge() bool {
+	if _, err := os.Stat(filepath.Join(db.getMergePath(), data.MergeFinishedFileName)); err == nil {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(db.getCompactPath(), data.CompactFinishedFileName))
+	return err == nil
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath)
+	nonMergeFileId, _, err := db.readMergeFinishedFile(dirPath)
+	return nonMergeFileId, err
+}
+
+// 读取标识 merge 完成的文件，返回最近没有参与 merge 的文件 id 和 merge 产生的文件数量
+// 旧的版本中没有记录文件数量，此时返回 -1
+func (db *DB) readMergeFinishedFile(dirPath string) (uint32, int, error) {
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(dirPath, db.fileIOType())
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	defer mergeFinishedFile.Close()
+	mergeFinishedFile.Codec = db.codec
 
-	record, _, err := mergeFinishedFile.ReadLogRecord(0)
+	record, size, err := mergeFinishedFile.ReadLogRecord(0)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
-
 	nonMergeFileId, err := strconv.Atoi(string(record.Value))
 	if err != nil {
-		return 0, nil
+		return 0, 0, ErrDataDirectoryCorrupted
+	}
+
+	var mergeFileNum = -1
+	record, _, err = mergeFinishedFile.ReadLogRecord(size)
+	if err == nil && string(record.Key) == mergeFileNumKey {
+		if mergeFileNum, err = strconv.Atoi(string(record.Value)); err != nil {
+			return 0, 0, ErrDataDirectoryCorrupted
+		}
+	} else if err != nil && err != io.EOF {
+		return 0, 0, err
 	}
 
-	return uint32(nonMergeFileId), nil
+	return uint32(nonMergeFileId), mergeFileNum, nil
 }
 
 func (db *DB) loadIndexFromHintFile() error {
+	if err := db.walkHintFile(func(key []byte, pos *data.LogRecordPos) {
+		if !db.compactedMergeFiles[pos.Fid] {
+			db.index.Put(key, pos)
+		}
+	}); err != nil {
+		return err
+	}
+
+	// 被 MergeFiles 重写过的文件中的数据从文件对应的 hint 文件中加载
+	var fileIds []int
+	for fid := range db.compactedMergeFiles {
+		fileIds = append(fileIds, int(fid))
+	}
+	sort.Ints(fileIds)
+	now := time.Now().UnixNano()
+	for _, fid := range fileIds {
+		dataFile := db.olderFiles[uint32(fid)]
+		if dataFile == nil {
+			return ErrDataFileNotFound
+		}
+		hints := db.loadFileHints(dataFile, true)
+		if hints.err != nil {
+			return hints.err
+		}
+		db.applyFileHints(hints.records, now)
+	}
+	return nil
+}
+
+// 遍历 hint 索引文件中的所有位置索引
+func (db *DB) walkHintFile(fn func(key []byte, pos *data.LogRecordPos)) error {
 	// 查看 hint 索引文件是否存在
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
@@ -286,10 +534,12 @@ func (db *DB) loadIndexFromHintFile() error {
 	}
 
 	// 打开 hint 索引文件
-	hintFile, err := data.OpenHintFile(db.options.DirPath)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, db.fileIOType())
 	if err != nil {
 		return err
 	}
+	defer hintFile.Close()
+	hintFile.Codec = db.codec
 
 	// 读取文件中的索引
 	var offset uint64 = 0
@@ -303,8 +553,7 @@ func (db *DB) loadIndexFromHintFile() error {
 		}
 
 		// 解码拿到实际的位置索引
-		pos := data.DecodeLogRecordPos(logRecord.Key)
-		db.index.Put(logRecord.Key, pos)
+		fn(logRecord.Key, data.DecodeLogRecordPos(logRecord.Value))
 		offset += size
 	}
 	return nil
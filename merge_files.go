@@ -0,0 +1,453 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+)
+
+const (
+	compactDirName         = "-compact"
+	compactFileIdKey       = "compact.file.id"
+	compactNumKey          = "compact.num"
+	compactMergeFileIdsKey = "compact.merge.file.ids"
+)
+
+// MergePolicy 选择 MergeFiles 重写哪些数据文件
+type MergePolicy struct {
+	// 文件中无效数据的比例达到该值才会重写，取值 0~1
+	GarbageRatio float32
+
+	// 文件中无效数据的大小达到该值才会重写，为 0 表示不限制
+	MinGarbageSize uint64
+
+	// 一次最多重写的文件数量，无效数据多的文件优先，为 0 表示不限制
+	MaxFiles int
+}
+
+// 一个被重写的数据文件
+type compactFile struct {
+	oldFile *data.DataFile
+	size    uint64        // 新文件的大小
+	hints   []*hintRecord // 新文件中每条记录的索引信息
+}
+
+// MergeFiles 只重写无效数据达到阈值的数据文件，其他文件保持不变
+// 每个文件重写之后使用原来的文件 id，被删除的 key 在文件中的删除记录只有在 key 已经被重新写入时才会清理，
+// 已经过期的数据也保留到下一次 Merge 再清理
+func (db *DB) MergeFiles(policy MergePolicy) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if policy.GarbageRatio < 0 || policy.GarbageRatio > 1 {
+		return ErrInvalidMergePolicy
+	}
+	db.mu.Lock()
+	// 如果数据库为空，直接返回
+	if db.activeFile == nil {
+		db.mu.Unlock()
+		return nil
+	}
+	if db.isMerging {
+		db.mu.Unlock()
+		return ErrMergeIsProgress
+	}
+	if db.hasPendingMerge() {
+		db.mu.Unlock()
+		return ErrMergeNotApplied
+	}
+
+	files, err := db.pickCompactFiles(policy)
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if len(files) == 0 {
+		db.mu.Unlock()
+		return ErrMergeRatioUnreached
+	}
+
+	// 查看剩余的空间容量是否可以容纳重写之后的数据量
+	var liveSize uint64
+	for _, file := range files {
+		liveSize += file.LiveSize
+	}
+	availableDiskSize, err := utils.AvailableDiskSize()
+	if err != nil {
+		db.mu.Unlock()
+		return err
+	}
+	if liveSize >= availableDiskSize {
+		db.mu.Unlock()
+		return ErrNoEnoughSpaceForMerge
+	}
+
+	var dataFiles []*data.DataFile
+	var oldSize uint64
+	for _, file := range files {
+		dataFiles = append(dataFiles, db.olderFiles[file.FileId])
+		oldSize += file.Size
+	}
+	db.isMerging = true
+	db.mu.Unlock()
+	defer func() {
+		db.mu.Lock()
+		db.isMerging = false
+		db.mu.Unlock()
+	}()
+	start := time.Now()
+
+	compactPath := db.getCompactPath()
+	if err := os.RemoveAll(compactPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(compactPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	var compacted []*compactFile
+	var newSize uint64
+	for _, dataFile := range dataFiles {
+		file, err := db.compactDataFile(compactPath, dataFile)
+		if err != nil {
+			return err
+		}
+		compacted = append(compacted, file)
+		newSize += file.size
+	}
+
+	if err := db.writeCompactFinishedFile(compactPath, dataFiles); err != nil {
+		return err
+	}
+	if err := db.swapCompactFiles(compacted); err != nil {
+		return err
+	}
+	db.recordMerge(start, oldSize, newSize)
+	return nil
+}
+
+// 按照策略选择需要重写的文件，活跃文件不参与
+// 在访问此方法前必须持有互斥锁
+func (db *DB) pickCompactFiles(policy MergePolicy) ([]DataFileStat, error) {
+	stats, err := db.dataFileStats()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []DataFileStat
+	for _, stat := range stats {
+		if stat.FileId == db.activeFile.FileId || stat.DeadSize == 0 || stat.DeadSize < policy.MinGarbageSize {
+			continue
+		}
+		if float32(stat.DeadSize)/float32(stat.Size) < policy.GarbageRatio {
+			continue
+		}
+		files = append(files, stat)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].DeadSize > files[j].DeadSize
+	})
+	if policy.MaxFiles > 0 && len(files) > policy.MaxFiles {
+		files = files[:policy.MaxFiles]
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+	return files, nil
+}
+
+// 将数据文件中仍然需要的记录按照原来的顺序写到 compact 目录中同名的新文件里，同时生成对应的 hint 文件
+func (db *DB) compactDataFile(compactPath string, dataFile *data.DataFile) (*compactFile, error) {
+	newFile, err := data.OpenDataFile(compactPath, dataFile.FileId, fio.StandardFIO)
+	if err != nil {
+		return nil, err
+	}
+	defer newFile.Close()
+	hint, err := newHintWriter(compactPath, dataFile.FileId, db.codec)
+	if err != nil {
+		return nil, err
+	}
+	var sealed bool
+	defer func() {
+		if !sealed {
+			_ = hint.close()
+		}
+	}()
+
+	file := &compactFile{oldFile: dataFile}
+	var offset uint64 = 0
+	for {
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+		db.ioLimiter.Wait(int(size))
+
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		if keep := db.keepCompactRecord(realKey, logRecord.Type, dataFile.FileId, offset); keep {
+			// 有效的数据清除事务标记，其他记录原样保留
+			if logRecord.Type == data.LogRecordNormal || logRecord.Type == data.LogRecordBlobPointer {
+				logRecord.Key = logRecordKeyWithSeq(realKey, nonTransactionSeqNum)
+			}
+			encRecord, encSize := db.codec.EncodeLogRecord(logRecord)
+			pos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: newFile.WriteOff, Size: encSize, Expire: logRecord.Expire}
+			if logRecord.Type == data.LogRecordBlobPointer {
+				pos.Blob = data.DecodeLogRecordPos(logRecord.Value)
+			}
+			if err := newFile.Write(encRecord); err != nil {
+				return nil, err
+			}
+			db.ioLimiter.Wait(len(encRecord))
+
+			record := &hintRecord{key: logRecord.Key, typ: logRecord.Type, pos: pos}
+			if err := hint.add(record); err != nil {
+				return nil, err
+			}
+			file.hints = append(file.hints, record)
+		}
+		offset += size
+	}
+
+	if err := newFile.Sync(); err != nil {
+		return nil, err
+	}
+	sealed = true
+	if err := hint.seal(); err != nil {
+		return nil, err
+	}
+	file.size = newFile.WriteOff
+	return file, nil
+}
+
+// 判断重写文件时是否需要保留这条记录
+// 只保留内存索引指向的数据，key 还不存在时保留删除记录，避免更早的文件中的数据在重启之后重新出现
+// 范围删除和事务完成的标识总是保留
+func (db *DB) keepCompactRecord(key []byte, typ data.LogRecordType, fid uint32, offset uint64) bool {
+	switch typ {
+	case data.LogRecordNormal, data.LogRecordBlobPointer:
+		pos := db.index.Get(key)
+		return pos != nil && pos.Fid == fid && pos.Offset == offset
+	case data.LogRecordDeleted:
+		return db.index.Get(key) == nil
+	default:
+		return true
+	}
+}
+
+// 写标识 MergeFiles 完成的文件，记录被重写的最大的文件 id、完成次数和被重写过的 merge 产生的文件
+func (db *DB) writeCompactFinishedFile(compactPath string, dataFiles []*data.DataFile) error {
+	db.mu.RLock()
+	compactedFileId, compactNum := db.compactedFileId, db.compactNum+1
+	mergeFileIds := make(map[uint32]bool)
+	for fid := range db.compactedMergeFiles {
+		mergeFileIds[fid] = true
+	}
+	for _, dataFile := range dataFiles {
+		if dataFile.FileId+1 > compactedFileId {
+			compactedFileId = dataFile.FileId + 1
+		}
+		if dataFile.FileId < db.nonMergeFileId {
+			mergeFileIds[dataFile.FileId] = true
+		}
+	}
+	db.mu.RUnlock()
+
+	var ids []string
+	for fid := range mergeFileIds {
+		ids = append(ids, strconv.Itoa(int(fid)))
+	}
+	sort.Strings(ids)
+
+	finishedFile, err := data.OpenCompactFinishedFile(compactPath, fio.StandardFIO)
+	if err != nil {
+		return err
+	}
+	defer finishedFile.Close()
+	finishedFile.Codec = db.codec
+
+	records := []*data.LogRecord{
+		{Key: []byte(compactFileIdKey), Value: []byte(strconv.Itoa(int(compactedFileId)))},
+		{Key: []byte(compactNumKey), Value: []byte(strconv.FormatUint(compactNum, 10))},
+		{Key: []byte(compactMergeFileIdsKey), Value: []byte(strings.Join(ids, ","))},
+	}
+	for _, record := range records {
+		encRecord, _ := db.codec.EncodeLogRecord(record)
+		if err := finishedFile.Write(encRecord); err != nil {
+			return err
+		}
+	}
+	return finishedFile.Sync()
+}
+
+// 用重写之后的文件替换旧的数据文件，并将内存索引中仍然指向旧文件的位置更新为新的位置
+func (db *DB) swapCompactFiles(files []*compactFile) error {
+	// 替换期间只读的进程不能加载文件
+	if err := db.lockSwap(); err != nil {
+		return err
+	}
+	defer db.unlockSwap()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := db.applyCompactFiles(); err != nil {
+		return err
+	}
+
+	// 之前的位置索引不再能和之后的比较，读过数据的事务提交时返回冲突
+	db.fileGeneration++
+
+	var retired []*data.DataFile
+	for _, file := range files {
+		fid := file.oldFile.FileId
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Codec = db.codec
+		db.olderFiles[fid] = dataFile
+		retired = append(retired, file.oldFile)
+
+		// 数据文件不会再写入，索引仍然指向这个文件说明 key 在重写之后没有被修改过，
+		// 否则新文件中的这条数据已经无效
+		db.fileGarbage[fid] = 0
+		for _, record := range file.hints {
+			if record.typ != data.LogRecordNormal && record.typ != data.LogRecordBlobPointer {
+				continue
+			}
+			realKey, _ := parseLogRecordKey(record.key)
+			if pos := db.index.Get(realKey); pos != nil && pos.Fid == fid {
+				db.index.Put(realKey, record.pos)
+			} else {
+				db.fileGarbage[fid] += record.pos.Size
+			}
+		}
+	}
+	db.resetReclaimSize()
+
+	if err := db.loadCompactFinished(); err != nil {
+		return err
+	}
+	db.retireFiles(retired)
+	return nil
+}
+
+func (db *DB) getCompactPath() string {
+	dir := path.Dir(path.Clean(db.options.DirPath))
+	base := path.Base(db.options.DirPath)
+	return filepath.Join(dir, base+compactDirName)
+}
+
+// 将 compact 目录中的文件移动到数据目录中，替换掉同名的旧文件
+// 标识 MergeFiles 完成的文件最后移动，中途失败之后再次执行可以继续完成替换
+func (db *DB) applyCompactFiles() error {
+	compactPath := db.getCompactPath()
+	if _, err := os.Stat(compactPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	// 没有完成就删除 compact 目录
+	if _, err := os.Stat(filepath.Join(compactPath, data.CompactFinishedFileName)); os.IsNotExist(err) {
+		return os.RemoveAll(compactPath)
+	}
+
+	dirEntries, err := os.ReadDir(compactPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, data.DataFileNameSuffix) && !strings.HasSuffix(name, data.HintFileNameSuffix) {
+			continue
+		}
+		if err := os.Rename(filepath.Join(compactPath, name), filepath.Join(db.options.DirPath, name)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(filepath.Join(compactPath, data.CompactFinishedFileName),
+		filepath.Join(db.options.DirPath, data.CompactFinishedFileName)); err != nil {
+		return err
+	}
+	return os.RemoveAll(compactPath)
+}
+
+// 读取标识 MergeFiles 完成的文件
+func (db *DB) loadCompactFinished() error {
+	compactedFileId, compactNum, mergeFileIds, err := readCompactFinishedFile(db.options.DirPath, db.codec, db.fileIOType())
+	if err != nil {
+		return err
+	}
+	db.compactedFileId, db.compactNum, db.compactedMergeFiles = compactedFileId, compactNum, mergeFileIds
+	return nil
+}
+
+// 读取标识 MergeFiles 完成的文件，返回被重写的最大文件 id 加一、完成的次数和被重写过的 merge 产生的文件
+// 文件不存在时都返回零值
+func readCompactFinishedFile(dirPath string, codec *data.Codec, ioType fio.FileIOType) (uint32, uint64, map[uint32]bool, error) {
+	if _, err := os.Stat(filepath.Join(dirPath, data.CompactFinishedFileName)); os.IsNotExist(err) {
+		return 0, 0, nil, nil
+	}
+	finishedFile, err := data.OpenCompactFinishedFile(dirPath, ioType)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer finishedFile.Close()
+	finishedFile.Codec = codec
+
+	values := make(map[string]string)
+	var offset uint64 = 0
+	for {
+		record, size, err := finishedFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, 0, nil, err
+		}
+		values[string(record.Key)] = string(record.Value)
+		offset += size
+	}
+
+	compactedFileId, err := strconv.ParseUint(values[compactFileIdKey], 10, 32)
+	if err != nil {
+		return 0, 0, nil, ErrDataDirectoryCorrupted
+	}
+	compactNum, err := strconv.ParseUint(values[compactNumKey], 10, 64)
+	if err != nil {
+		return 0, 0, nil, ErrDataDirectoryCorrupted
+	}
+	mergeFileIds := make(map[uint32]bool)
+	if ids := values[compactMergeFileIdsKey]; ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			fid, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return 0, 0, nil, ErrDataDirectoryCorrupted
+			}
+			mergeFileIds[uint32(fid)] = true
+		}
+	}
+	return uint32(compactedFileId), compactNum, mergeFileIds, nil
+}
+
+// 按照每个数据文件中的无效数据重新计算总的无效数据量
+// 在访问此方法前必须持有互斥锁
+func (db *DB) resetReclaimSize() {
+	db.reclaimSize = 0
+	for _, size := range db.fileGarbage {
+		db.reclaimSize += size
+	}
+}
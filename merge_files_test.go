@@ -0,0 +1,216 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-files")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	// 之后的文件中大部分数据被覆盖，同时删除最早写入的 key，删除记录在这些文件中
+	firstFile := db.activeFile.FileId + 1
+	var hot []byte
+	for i := 0; i < 2000; i++ {
+		hot = utils.RandomValue(64)
+		assert.Nil(t, db.Put([]byte("hot"), hot))
+		if i < 100 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		}
+	}
+	for i := 2000; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 每个文件的无效数据之和就是总的无效数据
+	stat := db.Stat()
+	assert.Equal(t, int(stat.DataFileNum), len(stat.DataFiles))
+	var deadSize uint64
+	sizes := make(map[uint32]uint64)
+	for _, file := range stat.DataFiles {
+		assert.Equal(t, file.Size, file.LiveSize+file.DeadSize)
+		deadSize += file.DeadSize
+		sizes[file.FileId] = file.Size
+	}
+	assert.Equal(t, stat.ReclaimableSize, deadSize)
+	assert.True(t, stat.DataFiles[firstFile].DeadSize > stat.DataFiles[firstFile].Size/2)
+
+	assert.Equal(t, ErrInvalidMergePolicy, db.MergeFiles(MergePolicy{GarbageRatio: 2}))
+	assert.Nil(t, db.MergeFiles(MergePolicy{GarbageRatio: 0.5, MaxFiles: 1}))
+
+	// 只重写了一个文件，其他文件没有变化
+	stat = db.Stat()
+	var rewritten []uint32
+	for _, file := range stat.DataFiles {
+		if file.Size != sizes[file.FileId] {
+			rewritten = append(rewritten, file.FileId)
+			assert.Equal(t, uint64(0), file.DeadSize)
+		}
+	}
+	assert.Equal(t, 1, len(rewritten))
+	assert.True(t, rewritten[0] >= firstFile)
+	assert.True(t, stat.ReclaimableSize < deadSize)
+
+	assert.Nil(t, db.MergeFiles(MergePolicy{GarbageRatio: 0.5}))
+	assert.Equal(t, ErrMergeRatioUnreached, db.MergeFiles(MergePolicy{GarbageRatio: 0.5}))
+
+	check := func(db *DB) {
+		for i := 0; i < 3000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			if i < 100 {
+				assert.Equal(t, ErrKeyNotFound, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+		}
+		val, err := db.Get([]byte("hot"))
+		assert.Nil(t, err)
+		assert.Equal(t, hot, val)
+	}
+	check(db)
+
+	// 重启之后删除的 key 不会重新出现
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+
+	// 重写过的文件中的 seq 不能再用来继续订阅
+	_, _, err = db2.WatchFrom(nil, eventSeq(firstFile, 0))
+	assert.Equal(t, ErrWatchSeqCompacted, err)
+	assert.Nil(t, db2.Close())
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+}
+
+// 重写 merge 产生的文件
+func TestDB_MergeFiles_Merged(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-files-merged")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	assert.True(t, db.nonMergeFileId > 1)
+
+	// merge 产生的第一个文件中的数据大部分被覆盖或者删除
+	for i := 0; i < 600; i++ {
+		if i%2 == 0 {
+			assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		} else {
+			assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value")))
+		}
+	}
+	assert.True(t, db.Stat().DataFiles[0].DeadSize > 0)
+	assert.Nil(t, db.MergeFiles(MergePolicy{GarbageRatio: 0.5}))
+	assert.Equal(t, uint64(0), db.Stat().DataFiles[0].DeadSize)
+
+	check := func(db *DB) {
+		for i := 0; i < 3000; i++ {
+			val, err := db.Get(utils.GetTestKey(i))
+			switch {
+			case i < 600 && i%2 == 0:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 600:
+				assert.Nil(t, err)
+				assert.Equal(t, []byte("new value"), val)
+			default:
+				assert.Nil(t, err)
+				assert.Equal(t, utils.GetTestKey(i), val)
+			}
+		}
+	}
+	check(db)
+
+	// 重启之后 hint 索引文件中指向重写过的文件的位置不再使用
+	assert.Nil(t, db.Close())
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+
+	// 再次 merge 之后所有的文件都被替换
+	assert.Nil(t, db2.Merge())
+	check(db2)
+	assert.Nil(t, db2.Close())
+	_, err = os.Stat(filepath.Join(dir, data.CompactFinishedFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	check(db3)
+	assert.Nil(t, db3.Close())
+}
+
+// 获取不到数据文件的大小时 Stat 不会 panic，MergeFiles 返回错误
+func TestDB_Stat_DataFileError(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat-data-file-error")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.True(t, len(db.Stat().DataFiles) > 1)
+
+	assert.Nil(t, db.olderFiles[0].IoManager.Close())
+	stat := db.Stat()
+	assert.Equal(t, uint(2000), stat.KeyNum)
+	assert.Nil(t, stat.DataFiles)
+	assert.NotNil(t, db.MergeFiles(MergePolicy{}))
+}
+
+// 获取不到数据目录的大小时 Stat 不会 panic，磁盘占用记为 0
+func TestDB_Stat_DirSizeError(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat-dir-size-error")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), utils.GetTestKey(1)))
+	assert.True(t, db.Stat().DiskSize > 0)
+
+	assert.Nil(t, os.RemoveAll(dir))
+	stat := db.Stat()
+	assert.Equal(t, uint64(0), stat.DiskSize)
+	assert.Equal(t, uint(1), stat.KeyNum)
+}
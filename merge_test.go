@@ -2,11 +2,14 @@ package bitcask_go
 
 import (
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
+	"bitcask-go/data"
 	"bitcask-go/utils"
 )
 
@@ -204,3 +207,132 @@ func TestDB_Merge5(t *testing.T) {
 		assert.NotNil(t, val)
 	}
 }
+
+// 过期的数据在 merge 之后被清理
+func TestDB_Merge_Expired(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-ttl")
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 50*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 1000; i < 2000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), time.Hour)
+		assert.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	err = db.Merge()
+	assert.Nil(t, err)
+
+	// 重启校验
+	err = db.Close()
+	assert.Nil(t, err)
+
+	db2, err := Open(opts)
+	defer func() {
+		_ = db2.Close()
+	}()
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, db2.index.Size())
+	for i := 1000; i < 2000; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.NotNil(t, val)
+	}
+}
+
+// merge 之后直接替换数据文件，之前创建的迭代器和快照依然可以读取
+func TestDB_Merge_Online(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-online")
+	opts.DataFileSize = 256 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 16000; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	dataFilesBefore, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+
+	iter := db.NewIterator(DefaultIteratorOptions)
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+
+	// merge 期间的写入
+	wg := new(sync.WaitGroup)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 19000; i < 21000; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("new value"))
+			assert.Nil(t, err)
+		}
+	}()
+	err = db.Merge()
+	assert.Nil(t, err)
+	wg.Wait()
+
+	// 不需要重启就可以读到 merge 之后的数据
+	dataFilesAfter, _ := filepath.Glob(filepath.Join(dir, "*"+data.DataFileNameSuffix))
+	assert.Less(t, len(dataFilesAfter), len(dataFilesBefore))
+	assert.Equal(t, 5000, len(db.ListKeys()))
+	for i := 16000; i < 21000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		if i < 19000 {
+			assert.Equal(t, utils.GetTestKey(i), val)
+		} else {
+			assert.Equal(t, []byte("new value"), val)
+		}
+	}
+
+	// 旧的读者依然读取旧的文件
+	assert.NotEmpty(t, db.retiredFiles)
+	var count int
+	for ; iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), val)
+		count++
+	}
+	assert.Equal(t, 4000, count)
+	val, err := snap.Get(utils.GetTestKey(19999))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(19999), val)
+
+	// 没有读者之后旧文件被关闭
+	iter.Close()
+	_ = snap.Close()
+	assert.Empty(t, db.retiredFiles)
+
+	// 重启之后数据一致
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, 5000, len(db2.ListKeys()))
+	val, err = db2.Get(utils.GetTestKey(16000))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(16000), val)
+	val, err = db2.Get(utils.GetTestKey(20000))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+}
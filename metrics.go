@@ -0,0 +1,188 @@
+package bitcask_go
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// 读写操作和 fsync 耗时的分桶上界（秒）
+var latencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5}
+
+// merge 耗时的分桶上界（秒）
+var mergeBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300, 600, 1800, 3600}
+
+// 存储引擎运行期间的统计，重启之后重新计数
+type metrics struct {
+	bytesWritten        uint64 // 写入数据文件和 blob 文件的字节数
+	mergeReclaimedBytes uint64 // merge 回收的字节数
+	fileRotations       uint64 // 活跃数据文件写满之后切换的次数
+	put                 *histogram
+	get                 *histogram
+	delete              *histogram
+	fsync               *histogram
+	merge               *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		put:    newHistogram(latencyBuckets),
+		get:    newHistogram(latencyBuckets),
+		delete: newHistogram(latencyBuckets),
+		fsync:  newHistogram(latencyBuckets),
+		merge:  newHistogram(mergeBuckets),
+	}
+}
+
+// 耗时分布，每个桶只记录落在其中的次数，导出时再累加
+type histogram struct {
+	bounds []float64
+	counts []uint64 // 比 bounds 多一个，最后一个是超过所有上界的次数
+	sum    uint64   // 总耗时（纳秒）
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := 0
+	for i < len(h.bounds) && seconds > h.bounds[i] {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+}
+
+// 记录从 start 开始的耗时
+func (h *histogram) since(start time.Time) {
+	h.observe(time.Since(start))
+}
+
+func (h *histogram) snapshot() Histogram {
+	var snapshot Histogram
+	var count uint64
+	for i, bound := range h.bounds {
+		count += atomic.LoadUint64(&h.counts[i])
+		snapshot.Buckets = append(snapshot.Buckets, HistogramBucket{UpperBound: bound, Count: count})
+	}
+	snapshot.Count = count + atomic.LoadUint64(&h.counts[len(h.bounds)])
+	snapshot.Sum = time.Duration(atomic.LoadUint64(&h.sum)).Seconds()
+	return snapshot
+}
+
+// Metrics 存储引擎的监控指标快照
+// 索引的大小只以 key 的数量（Stat.KeyNum）导出，内存索引实际占用的内存没有统计，可以参考 Go 运行时的内存指标
+type Metrics struct {
+	Puts                uint64 // Put 调用次数
+	Gets                uint64 // Get 调用次数
+	Deletes             uint64 // Delete 调用次数
+	BytesWritten        uint64 // 写入数据文件和 blob 文件的字节数
+	Fsyncs              uint64 // 持久化活跃文件的次数
+	Merges              uint64 // 成功完成的 Merge 和 MergeFiles 次数
+	MergeReclaimedBytes uint64 // merge 回收的磁盘空间
+	FileRotations       uint64 // 活跃数据文件写满之后切换的次数
+
+	PutLatency    Histogram
+	GetLatency    Histogram
+	DeleteLatency Histogram
+	FsyncDuration Histogram
+	MergeDuration Histogram
+
+	Stat Stat
+}
+
+// Histogram 耗时分布
+type Histogram struct {
+	Buckets []HistogramBucket // 按照上界从小到大排列，计数是累加的
+	Count   uint64
+	Sum     float64 // 总耗时（秒）
+}
+
+// HistogramBucket 耗时不超过 UpperBound 秒的次数
+type HistogramBucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Metrics 返回存储引擎的监控指标，计数从打开数据库开始
+// 获取不到文件大小时对应的指标记为 0，不会返回错误
+func (db *DB) Metrics() *Metrics {
+	m := &Metrics{
+		BytesWritten:        atomic.LoadUint64(&db.metrics.bytesWritten),
+		MergeReclaimedBytes: atomic.LoadUint64(&db.metrics.mergeReclaimedBytes),
+		FileRotations:       atomic.LoadUint64(&db.metrics.fileRotations),
+		PutLatency:          db.metrics.put.snapshot(),
+		GetLatency:          db.metrics.get.snapshot(),
+		DeleteLatency:       db.metrics.delete.snapshot(),
+		FsyncDuration:       db.metrics.fsync.snapshot(),
+		MergeDuration:       db.metrics.merge.snapshot(),
+		Stat:                *db.Stat(),
+	}
+	m.Puts = m.PutLatency.Count
+	m.Gets = m.GetLatency.Count
+	m.Deletes = m.DeleteLatency.Count
+	m.Fsyncs = m.FsyncDuration.Count
+	m.Merges = m.MergeDuration.Count
+	return m
+}
+
+// WritePrometheus 以 Prometheus 文本格式输出监控指标
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	writeMetric := func(name, typ, help string, value float64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n", name, help, name, typ, name, formatFloat(value))
+	}
+	writeHistogram := func(name, help string, h Histogram) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		for _, bucket := range h.Buckets {
+			fmt.Fprintf(bw, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bucket.UpperBound), bucket.Count)
+		}
+		fmt.Fprintf(bw, "%s_bucket{le=\"+Inf\"} %d\n", name, h.Count)
+		fmt.Fprintf(bw, "%s_sum %s\n%s_count %d\n", name, formatFloat(h.Sum), name, h.Count)
+	}
+
+	writeMetric("bitcask_puts_total", "counter", "Number of Put calls.", float64(m.Puts))
+	writeMetric("bitcask_gets_total", "counter", "Number of Get calls.", float64(m.Gets))
+	writeMetric("bitcask_deletes_total", "counter", "Number of Delete calls.", float64(m.Deletes))
+	writeMetric("bitcask_written_bytes_total", "counter", "Bytes written to data and blob files.", float64(m.BytesWritten))
+	writeMetric("bitcask_fsyncs_total", "counter", "Number of fsyncs of the active files.", float64(m.Fsyncs))
+	writeMetric("bitcask_merges_total", "counter", "Number of completed merges.", float64(m.Merges))
+	writeMetric("bitcask_merge_reclaimed_bytes_total", "counter", "Disk space reclaimed by merges.", float64(m.MergeReclaimedBytes))
+	writeMetric("bitcask_data_file_rotations_total", "counter", "Number of active data file rotations.", float64(m.FileRotations))
+
+	writeHistogram("bitcask_put_duration_seconds", "Latency of Put calls.", m.PutLatency)
+	writeHistogram("bitcask_get_duration_seconds", "Latency of Get calls.", m.GetLatency)
+	writeHistogram("bitcask_delete_duration_seconds", "Latency of Delete calls.", m.DeleteLatency)
+	writeHistogram("bitcask_fsync_duration_seconds", "Duration of fsyncs of the active files.", m.FsyncDuration)
+	writeHistogram("bitcask_merge_duration_seconds", "Duration of completed merges.", m.MergeDuration)
+
+	stat := m.Stat
+	writeMetric("bitcask_keys", "gauge", "Number of keys in the index, the memory used by the index is not exported.", float64(stat.KeyNum))
+	writeMetric("bitcask_data_files", "gauge", "Number of data files.", float64(stat.DataFileNum))
+	writeMetric("bitcask_reclaimable_bytes", "gauge", "Bytes of invalid data that merge can reclaim.", float64(stat.ReclaimableSize))
+	writeMetric("bitcask_disk_bytes", "gauge", "Disk space used by the data directory.", float64(stat.DiskSize))
+	writeMetric("bitcask_blob_files", "gauge", "Number of blob files.", float64(stat.BlobFileNum))
+	writeMetric("bitcask_blob_reclaimable_bytes", "gauge", "Bytes of invalid values that BlobGC can reclaim.", float64(stat.BlobReclaimableSize))
+	writeMetric("bitcask_truncated_bytes", "gauge", "Bytes of corrupted data truncated at open.", float64(stat.TruncatedSize))
+	writeMetric("bitcask_value_cache_hits_total", "counter", "Number of value cache hits.", float64(stat.ValueCacheHits))
+	writeMetric("bitcask_value_cache_misses_total", "counter", "Number of value cache misses.", float64(stat.ValueCacheMisses))
+
+	fmt.Fprintf(bw, "# HELP bitcask_data_file_bytes Size of each data file.\n# TYPE bitcask_data_file_bytes gauge\n")
+	for _, file := range stat.DataFiles {
+		fmt.Fprintf(bw, "bitcask_data_file_bytes{file_id=\"%d\"} %d\n", file.FileId, file.Size)
+	}
+	fmt.Fprintf(bw, "# HELP bitcask_data_file_dead_bytes Bytes of invalid data in each data file.\n# TYPE bitcask_data_file_dead_bytes gauge\n")
+	for _, file := range stat.DataFiles {
+		fmt.Fprintf(bw, "bitcask_data_file_dead_bytes{file_id=\"%d\"} %d\n", file.FileId, file.DeadSize)
+	}
+	return bw.Flush()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,80 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_Metrics(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-metrics")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	for i := 0; i < 10; i++ {
+		_, _ = db.Get(utils.GetTestKey(i))
+	}
+	assert.Nil(t, db.Sync())
+	assert.Nil(t, db.Merge())
+
+	m := db.Metrics()
+	assert.Equal(t, uint64(1000), m.Puts)
+	assert.Equal(t, uint64(500), m.Deletes)
+	assert.Equal(t, uint64(10), m.Gets)
+	assert.True(t, m.BytesWritten > 0)
+	assert.True(t, m.FileRotations > 0)
+	assert.True(t, m.Fsyncs > m.FileRotations)
+	assert.Equal(t, uint64(1), m.Merges)
+	assert.True(t, m.MergeReclaimedBytes > 0)
+	assert.Equal(t, uint(500), m.Stat.KeyNum)
+
+	// 分桶的计数是累加的
+	buckets := m.PutLatency.Buckets
+	assert.Equal(t, len(latencyBuckets), len(buckets))
+	for i := 1; i < len(buckets); i++ {
+		assert.True(t, buckets[i].Count >= buckets[i-1].Count)
+	}
+	assert.True(t, buckets[len(buckets)-1].Count <= m.PutLatency.Count)
+	assert.True(t, m.PutLatency.Sum > 0)
+
+	var buf bytes.Buffer
+	assert.Nil(t, m.WritePrometheus(&buf))
+	text := buf.String()
+	for _, line := range []string{
+		"# TYPE bitcask_puts_total counter",
+		"bitcask_puts_total 1000",
+		"bitcask_deletes_total 500",
+		"bitcask_merges_total 1",
+		"# TYPE bitcask_put_duration_seconds histogram",
+		"bitcask_put_duration_seconds_bucket{le=\"+Inf\"} 1000",
+		"bitcask_put_duration_seconds_count 1000",
+		"bitcask_keys 500",
+		"bitcask_data_file_bytes{file_id=\"0\"}",
+	} {
+		assert.True(t, strings.Contains(text, line), line)
+	}
+	assert.True(t, strings.HasSuffix(text, "\n"))
+
+	// 文件系统出错时依然可以导出
+	assert.Nil(t, os.RemoveAll(dir))
+	buf.Reset()
+	assert.Nil(t, db.Metrics().WritePrometheus(&buf))
+	assert.True(t, strings.Contains(buf.String(), "bitcask_disk_bytes 0"))
+}
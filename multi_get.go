@@ -0,0 +1,114 @@
+package bitcask_go
+
+import (
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// MultiGet 读取多个 key 的 value，结果和错误按照 keys 的顺序返回
+// 在一次加锁中取出所有的位置索引，按照文件和偏移排序之后读取，不同文件之间并行读取
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	// 持有 fileView，释放锁之后读取期间文件不会被 merge 关闭
+	db.mu.RLock()
+	view := db.newFileView()
+	now := time.Now().UnixNano()
+	var reads []multiGetRead
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil || pos.IsExpired(now) {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		reads = append(reads, multiGetRead{index: i, pos: pos})
+	}
+	db.mu.RUnlock()
+	defer db.releaseFileView(view)
+
+	sort.Slice(reads, func(i, j int) bool {
+		return reads[i].less(reads[j])
+	})
+
+	// 按照文件分组，每个文件中的读取按照偏移顺序进行
+	var groups [][]multiGetRead
+	for i := 0; i < len(reads); i++ {
+		if i == 0 || reads[i].file() != reads[i-1].file() {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], reads[i])
+	}
+
+	readGroup := func(group []multiGetRead) {
+		for _, read := range group {
+			values[read.index], errs[read.index] = view.getValuesByPosition(read.pos)
+		}
+	}
+	if len(groups) == 1 {
+		readGroup(groups[0])
+		return values, errs
+	}
+
+	// 最多同时读取 NumCPU 个文件
+	var wg sync.WaitGroup
+	tokens := make(chan struct{}, runtime.NumCPU())
+	for _, group := range groups {
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func(group []multiGetRead) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			readGroup(group)
+		}(group)
+	}
+	wg.Wait()
+	return values, errs
+}
+
+// MultiGet 中的一次读取，index 是 key 在参数中的位置
+type multiGetRead struct {
+	index int
+	pos   *data.LogRecordPos
+}
+
+// value 所在的文件，blob 文件和数据文件分开
+type multiGetFile struct {
+	blob bool
+	fid  uint32
+}
+
+func (r multiGetRead) file() multiGetFile {
+	if r.pos.Blob != nil {
+		return multiGetFile{blob: true, fid: r.pos.Blob.Fid}
+	}
+	return multiGetFile{fid: r.pos.Fid}
+}
+
+func (r multiGetRead) offset() uint64 {
+	if r.pos.Blob != nil {
+		return r.pos.Blob.Offset
+	}
+	return r.pos.Offset
+}
+
+func (r multiGetRead) less(other multiGetRead) bool {
+	a, b := r.file(), other.file()
+	if a.blob != b.blob {
+		return !a.blob
+	}
+	if a.fid != b.fid {
+		return a.fid < b.fid
+	}
+	return r.offset() < other.offset()
+}
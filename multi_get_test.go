@@ -0,0 +1,59 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_MultiGet(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.BlobThreshold = 64
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	bigValue := utils.RandomValue(128)
+	assert.Nil(t, db.Put([]byte("blob"), bigValue))
+	assert.Nil(t, db.Delete(utils.GetTestKey(5)))
+	assert.True(t, db.activeFile.FileId > 1)
+
+	keys := [][]byte{
+		utils.GetTestKey(1999),
+		[]byte("blob"),
+		utils.GetTestKey(0),
+		utils.GetTestKey(5),
+		nil,
+		[]byte("not exist"),
+		utils.GetTestKey(1000),
+	}
+	values, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+
+	assert.Nil(t, errs[0])
+	assert.Equal(t, utils.GetTestKey(1999), values[0])
+	assert.Nil(t, errs[1])
+	assert.Equal(t, bigValue, values[1])
+	assert.Nil(t, errs[2])
+	assert.Equal(t, utils.GetTestKey(0), values[2])
+	assert.Equal(t, ErrKeyNotFound, errs[3])
+	assert.Equal(t, ErrKeyIsEmpty, errs[4])
+	assert.Equal(t, ErrKeyNotFound, errs[5])
+	assert.Nil(t, errs[6])
+	assert.Equal(t, utils.GetTestKey(1000), values[6])
+
+	values, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(values))
+	assert.Equal(t, 0, len(errs))
+}
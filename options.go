@@ -1,6 +1,9 @@
 package bitcask_go
 
-import "os"
+import (
+	"os"
+	"time"
+)
 
 type Options struct {
 	// 数据库数据目录
@@ -23,6 +26,48 @@ type Options struct {
 
 	// 数据文件合并的阈值
 	DataFileMergeRatio float32
+
+	// value 的压缩算法，修改之后 merge 会按照新的算法重写数据
+	Compression CompressionType
+
+	// AES-GCM 加密密钥，长度为 16、24 或 32 字节，为空表示不加密
+	EncryptionKey []byte
+
+	// 之前使用过的密钥，只用于读取旧数据，merge 之后所有数据都会使用 EncryptionKey 重新加密
+	PreviousEncryptionKeys [][]byte
+
+	// value 超过该大小时单独存储到 blob 文件中，0 表示不启用
+	BlobThreshold uint
+
+	// 后台自动 merge 的检查间隔，0 表示不按时间检查
+	AutoMergeInterval time.Duration
+
+	// 每写入多少条记录检查一次是否需要自动 merge，0 表示不按写入次数检查
+	// AutoMergeInterval 和 AutoMergeWrites 都为 0 时不启用自动 merge
+	AutoMergeWrites uint
+
+	// 允许自动 merge 的时间段，为空表示任何时间都可以
+	AutoMergeWindows []MergeWindow
+
+	// 自动 merge 出错时的回调，未达到阈值和已经在 merge 中不算出错，为空时输出到日志
+	AutoMergeErrorHandler func(err error)
+
+	// 旧的数据文件损坏时是否截断损坏位置之后的数据，默认打开失败
+	// 活跃文件末尾写入中断的数据总是会被截断
+	RepairCorruption bool
+
+	// 以只读方式打开，不获取文件锁，可以和写入的进程同时打开同一个目录
+	// 所有的写操作返回 ErrReadOnly，调用 Refresh 读取写入者新写入的数据
+	// 打开和 Refresh 加载文件期间持有 swap-lock 的共享锁，写入的进程替换 merge 产生的文件时需要等待加载完成
+	// 只读模式下不使用 mmap，数据目录可以没有写权限
+	ReadOnly bool
+
+	// 读取 value 的 LRU 缓存大小（字节），0 表示不启用
+	// 缓存以数据所在的文件和偏移作为 key，merge 或者删除文件之后对应的缓存失效
+	ValueCacheSize uint64
+
+	// merge 和备份每秒最多读写的字节数，0 表示不限速，运行时可以通过 DB.SetBackgroundIOBytesPerSec 修改
+	BackgroundIOBytesPerSec uint64
 }
 
 // IteratorOptions 索引迭代器配置项
@@ -31,13 +76,17 @@ type IteratorOptions struct {
 	Prefix []byte
 	// 是否反向遍历，默认 false 是正向
 	Reverse bool
+	// 遍历范围的下界，包括 LowerBound 本身，为空表示没有下界
+	LowerBound []byte
+	// 遍历范围的上界，不包括 UpperBound 本身，为空表示没有上界
+	UpperBound []byte
 }
 
 // WriteBatchOptions 批量写配置项
 type WriteBatchOptions struct {
 	MaxBatchNum uint // 一个 batch 最多的数据量
 
-	SyncWrites bool // 提交事务时是否进行持久化
+	SyncWrites bool // 提交事务时是否进行持久化，Options.SyncWrites 为 true 时总是持久化，并发的提交只持久化一次
 }
 
 type IndexerType = int8
@@ -53,6 +102,19 @@ const (
 	BPlusTree
 )
 
+type CompressionType = byte
+
+const (
+	// 不压缩
+	NoCompression CompressionType = iota
+
+	// 使用 deflate 算法压缩
+	FlateCompression
+
+	// 使用 gzip 格式压缩
+	GzipCompression
+)
+
 var DefaultOptions = Options{
 	DirPath:            os.TempDir(),
 	DataFileSize:       256 * 1024 * 1024, // 256 MB
@@ -61,6 +123,7 @@ var DefaultOptions = Options{
 	IndexType:          BTree,
 	MMapAtStartup:      true,
 	DataFileMergeRatio: 0.5,
+	Compression:        NoCompression,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -0,0 +1,94 @@
+package bitcask_go
+
+import (
+	"bytes"
+
+	"bitcask-go/data"
+)
+
+// DeletePrefix 删除所有以 prefix 开头的 key，只写入一条范围删除的记录
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// DeleteRange 删除 [start, end) 区间中的所有 key，只写入一条范围删除的记录
+// start 为空表示从第一个 key 开始，end 为空表示一直到最后一个 key
+func (db *DB) DeleteRange(start, end []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+
+	// 范围删除不能和组提交中的其他写入交错，组内的写入依赖内存索引判断 key 的状态
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// 区间中没有 key 时不写入
+	if len(db.indexRange(start, end, 1)) == 0 {
+		return nil
+	}
+
+	// end 存储在 value 中
+	pos, err := db.appendLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, nonTransactionSeqNum),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+	})
+	if err != nil {
+		return err
+	}
+	db.reclaim(pos)
+	db.deleteIndexRange(start, end)
+	db.notifyWatchers(eventSeq(pos.Fid, pos.Offset+pos.Size), []Change{{Type: EventDeleteRange, Key: start, Value: end}})
+	return nil
+}
+
+// 获取内存索引中 [start, end) 区间的 key，limit 大于 0 时最多返回 limit 个
+// 在访问此方法前必须持有互斥锁（读锁即可）
+func (db *DB) indexRange(start, end []byte, limit int) [][]byte {
+	var keys [][]byte
+	iterator := db.index.Iterator(false)
+	defer iterator.Close()
+	for iterator.Seek(start); iterator.Valid(); iterator.Next() {
+		if !inRange(iterator.Key(), start, end) {
+			break
+		}
+		keys = append(keys, iterator.Key())
+		if limit > 0 && len(keys) >= limit {
+			break
+		}
+	}
+	return keys
+}
+
+// 从内存索引中删除 [start, end) 区间的 key
+// 在访问此方法前必须持有互斥锁
+func (db *DB) deleteIndexRange(start, end []byte) {
+	for _, key := range db.indexRange(start, end, 0) {
+		if oldPos, _ := db.index.Delete(key); oldPos != nil {
+			db.reclaim(oldPos)
+		}
+	}
+}
+
+// key 是否在 [start, end) 区间中，end 为空表示没有上界
+func inRange(key, start, end []byte) bool {
+	return bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0)
+}
+
+// 比所有以 prefix 开头的 key 都大的最小的 key，prefix 全部是 0xff 时返回 nil
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package bitcask_go
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-prefix")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant:a:%04d", i)), []byte("value")))
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("tenant:b:%04d", i)), []byte("value")))
+	}
+	assert.Nil(t, db.Put([]byte("tenant:a"), []byte("value")))
+
+	ch, cancel := db.Watch([]byte("tenant:a:"))
+	defer cancel()
+
+	// 只写入一条范围删除的记录
+	writeOff := db.activeFile.WriteOff
+	assert.Nil(t, db.DeletePrefix([]byte("tenant:a:")))
+	assert.True(t, db.activeFile.WriteOff-writeOff < 64)
+	assert.Equal(t, 1001, len(db.ListKeys()))
+	_, err = db.Get([]byte("tenant:a:0001"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("tenant:a"))
+	assert.Nil(t, err)
+
+	event := receiveEvent(t, ch)
+	assert.Equal(t, []Change{{Type: EventDeleteRange, Key: []byte("tenant:a:"), Value: []byte("tenant:a;")}}, event.Changes)
+
+	// 区间中没有 key 时不写入
+	writeOff = db.activeFile.WriteOff
+	assert.Nil(t, db.DeletePrefix([]byte("tenant:a:")))
+	assert.Equal(t, writeOff, db.activeFile.WriteOff)
+	assert.Equal(t, ErrKeyIsEmpty, db.DeletePrefix(nil))
+
+	// 删除之后重新写入的 key 不受影响，范围删除的记录所在的文件写满之后从 hint 文件加载
+	assert.Nil(t, db.Put([]byte("tenant:a:0001"), []byte("new value")))
+	activeFid := db.activeFile.FileId
+	for i := 0; db.activeFile.FileId == activeFid; i++ {
+		assert.Nil(t, db.Put([]byte(fmt.Sprintf("other:%04d", i)), []byte("value")))
+	}
+	assert.Nil(t, db.Close())
+
+	check := func(db *DB) {
+		_, err := db.Get([]byte("tenant:a:0002"))
+		assert.Equal(t, ErrKeyNotFound, err)
+		val, err := db.Get([]byte("tenant:a:0001"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new value"), val)
+		val, err = db.Get([]byte("tenant:b:0002"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("value"), val)
+	}
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	check(db2)
+
+	// merge 之后被删除的 key 不会被重写
+	assert.Nil(t, db2.Merge())
+	check(db2)
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	check(db3)
+
+	// 离线校验时同样应用范围删除
+	keyNum := len(db3.ListKeys())
+	assert.Nil(t, db3.Close())
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, keyNum, report.Keys)
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		assert.Nil(t, db.Put([]byte(key), []byte(key)))
+	}
+	assert.Nil(t, db.DeleteRange([]byte("b"), []byte("d")))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("d"), []byte("e")}, db.ListKeys())
+
+	// 结束位置为空表示没有上界
+	assert.Nil(t, db.DeleteRange([]byte("e"), nil))
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("d")}, db.ListKeys())
+
+	// 不为 nil 的空结束位置同样没有上界
+	assert.Nil(t, db.Put([]byte("f"), []byte("f")))
+	assert.Nil(t, db.DeleteRange([]byte("c"), []byte{}))
+	assert.Equal(t, [][]byte{[]byte("a")}, db.ListKeys())
+
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	assert.Equal(t, [][]byte{[]byte("a")}, db2.ListKeys())
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, []byte("ab"), prefixEnd([]byte("aa")))
+	assert.Equal(t, []byte("b"), prefixEnd([]byte{'a', 0xff}))
+	assert.Nil(t, prefixEnd([]byte{0xff, 0xff}))
+}
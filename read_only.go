@@ -0,0 +1,312 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofrs/flock"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/index"
+)
+
+// 打开数据文件使用的 IO 类型，只读模式下以只读方式打开
+func (db *DB) fileIOType() fio.FileIOType {
+	if db.options.ReadOnly {
+		return fio.ReadOnlyFIO
+	}
+	return fio.StandardFIO
+}
+
+// 获取替换 merge 文件的锁，写入的进程替换文件期间持有排他锁，只读的进程加载文件期间持有共享锁
+// 锁文件由写入的进程创建，不存在时说明写入的进程还没有打开过这个目录，只读的进程不加锁
+func (db *DB) lockSwap() error {
+	db.swapMu.Lock()
+	if db.swapLock == nil {
+		path := filepath.Join(db.options.DirPath, swapLockName)
+		if _, err := os.Stat(path); db.options.ReadOnly && err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			db.swapMu.Unlock()
+			return err
+		}
+		db.swapLock = flock.New(path)
+	}
+
+	var err error
+	if db.options.ReadOnly {
+		err = db.swapLock.RLock()
+	} else {
+		err = db.swapLock.Lock()
+	}
+	if err != nil {
+		db.swapMu.Unlock()
+	}
+	return err
+}
+
+func (db *DB) unlockSwap() {
+	if db.swapLock != nil {
+		_ = db.swapLock.Unlock()
+	}
+	db.swapMu.Unlock()
+}
+
+// 加载索引之后写入的进程是否进行了 merge，merge 产生的文件会复用旧的文件 id
+func (db *DB) mergeChanged() (bool, error) {
+	if db.hasPendingMerge() {
+		return true, nil
+	}
+
+	var nonMergeFileId uint32
+	if _, err := os.Stat(filepath.Join(db.options.DirPath, data.MergeFinishedFileName)); err == nil {
+		fid, err := db.getNonMergeFileId(db.options.DirPath)
+		if err != nil {
+			return false, err
+		}
+		nonMergeFileId = fid
+	}
+	if nonMergeFileId != db.nonMergeFileId {
+		return true, nil
+	}
+
+	// MergeFiles 重写了文件
+	_, compactNum, _, err := readCompactFinishedFile(db.options.DirPath, db.codec, db.fileIOType())
+	if err != nil {
+		return false, err
+	}
+	return compactNum != db.compactNum, nil
+}
+
+// 关闭打开的数据文件和 blob 文件
+func (db *DB) closeFiles() {
+	if db.activeFile != nil {
+		_ = db.activeFile.Close()
+	}
+	for _, file := range db.olderFiles {
+		_ = file.Close()
+	}
+	for _, file := range db.blobFiles {
+		_ = file.Close()
+	}
+}
+
+// Refresh 只读模式下读取写入的进程在上次加载之后新写入的数据
+// 写入的进程进行了 merge 时重新加载所有的文件，merge 的文件还没有替换完成时返回 ErrMergeNotApplied，稍后重试即可
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+	if err := db.lockSwap(); err != nil {
+		return err
+	}
+	defer db.unlockSwap()
+
+	changed, err := db.mergeChanged()
+	if err != nil {
+		return err
+	}
+	if changed {
+		return db.reload()
+	}
+	return db.refreshIncremental()
+}
+
+// 重新加载所有的文件和索引，被替换掉的文件在没有读者使用之后关闭
+func (db *DB) reload() error {
+	fresh := &DB{
+		options:    db.options,
+		mu:         new(sync.RWMutex),
+		olderFiles: make(map[uint32]*data.DataFile),
+		blobFiles:  make(map[uint32]*data.DataFile),
+		index:      index.NewIndexer(db.options.IndexType, db.options.DirPath, db.options.SyncWrites),
+		codec:      db.codec,
+	}
+	if err := fresh.load(); err != nil {
+		fresh.closeFiles()
+		return err
+	}
+	// 加载期间写入的进程又替换了文件
+	if changed, err := fresh.mergeChanged(); err != nil || changed {
+		fresh.closeFiles()
+		if err == nil {
+			err = ErrMergeNotApplied
+		}
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var retired []*data.DataFile
+	if db.activeFile != nil {
+		retired = append(retired, db.activeFile)
+	}
+	for _, file := range db.olderFiles {
+		retired = append(retired, file)
+	}
+	for _, file := range db.blobFiles {
+		retired = append(retired, file)
+	}
+
+	db.fileIds = fresh.fileIds
+	db.activeFile = fresh.activeFile
+	db.olderFiles = fresh.olderFiles
+	db.index = fresh.index
+	db.seqNum = fresh.seqNum
+	db.reclaimSize = fresh.reclaimSize
+	db.nonMergeFileId = fresh.nonMergeFileId
+	db.pendingTxns = fresh.pendingTxns
+	db.activeBlobFile = fresh.activeBlobFile
+	db.blobFiles = fresh.blobFiles
+	db.blobGarbage = fresh.blobGarbage
+	db.fileGarbage = fresh.fileGarbage
+	db.compactedFileId = fresh.compactedFileId
+	db.compactNum = fresh.compactNum
+	db.compactedMergeFiles = fresh.compactedMergeFiles
+
+	db.retireFiles(retired)
+	return nil
+}
+
+// 从活跃文件上次读到的位置继续读取，并加载新产生的数据文件和 blob 文件
+func (db *DB) refreshIncremental() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	removedBlobs, err := db.refreshBlobFiles()
+	if err != nil {
+		return err
+	}
+
+	fileIds, err := db.readFileIds(data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	// 打开新产生的数据文件，加载完成之前出错需要关闭
+	var dataFiles, newFiles []*data.DataFile
+	closeNewFiles := func() {
+		for _, file := range newFiles {
+			_ = file.Close()
+		}
+	}
+	if db.activeFile != nil {
+		dataFiles = append(dataFiles, db.activeFile)
+	}
+	for _, fid := range fileIds {
+		if db.activeFile != nil && uint32(fid) <= db.activeFile.FileId {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), fio.ReadOnlyFIO)
+		if err != nil {
+			closeNewFiles()
+			return err
+		}
+		dataFile.Codec = db.codec
+		dataFiles = append(dataFiles, dataFile)
+		newFiles = append(newFiles, dataFile)
+	}
+	if len(dataFiles) == 0 {
+		return nil
+	}
+
+	// 先读取所有文件的索引信息，全部有效之后再更新到内存索引中
+	results := make([]*fileHints, len(dataFiles))
+	for i, dataFile := range dataFiles {
+		last := i == len(dataFiles)-1
+		if dataFile == db.activeFile {
+			results[i] = scanDataFile(dataFile, dataFile.WriteOff)
+		} else {
+			results[i] = db.loadFileHints(dataFile, !last)
+		}
+		if err := checkRefreshedFile(dataFile, results[i], last); err != nil {
+			closeNewFiles()
+			return err
+		}
+	}
+
+	if db.pendingTxns == nil {
+		db.pendingTxns = make(map[uint64][]*data.TransactionRecord)
+	}
+	now := time.Now().UnixNano()
+	for i, dataFile := range dataFiles {
+		db.applyFileHints(results[i].records, now)
+		dataFile.WriteOff = results[i].size
+	}
+
+	// id 最大的文件是新的活跃文件，之前的活跃文件已经写满
+	for _, dataFile := range dataFiles[:len(dataFiles)-1] {
+		db.olderFiles[dataFile.FileId] = dataFile
+	}
+	db.activeFile = dataFiles[len(dataFiles)-1]
+
+	// blob 文件被 BlobGC 删除之前，指向它的数据已经被重写到了新的数据文件中
+	for _, blobFile := range removedBlobs {
+		delete(db.blobFiles, blobFile.FileId)
+		delete(db.blobGarbage, blobFile.FileId)
+	}
+	db.retireFiles(removedBlobs)
+	return nil
+}
+
+// 检查新读取的记录，写入的进程可能正在写入最后一个文件，末尾不完整的记录在下次读取
+// 已经写满的文件中出现无效的记录说明数据被破坏了
+func checkRefreshedFile(dataFile *data.DataFile, hints *fileHints, last bool) error {
+	if hints.err != nil && hints.err != data.ErrInvalidCRC && hints.err != io.ErrUnexpectedEOF {
+		return hints.err
+	}
+	if last {
+		return nil
+	}
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if hints.err != nil || hints.size != fileSize {
+		return ErrDataFileCorrupted
+	}
+	return nil
+}
+
+// 打开新产生的 blob 文件并更新大小，返回已经被删除的 blob 文件，在数据文件加载完成之后再移除
+// 在访问此方法前必须持有互斥锁
+func (db *DB) refreshBlobFiles() ([]*data.DataFile, error) {
+	fileIds, err := db.readFileIds(data.BlobFileNameSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := make(map[uint32]bool, len(fileIds))
+	for _, fid := range fileIds {
+		exists[uint32(fid)] = true
+		blobFile, ok := db.blobFiles[uint32(fid)]
+		if !ok {
+			if blobFile, err = data.OpenBlobFile(db.options.DirPath, uint32(fid), fio.ReadOnlyFIO); err != nil {
+				return nil, err
+			}
+			blobFile.Codec = db.codec
+			db.blobFiles[uint32(fid)] = blobFile
+		}
+
+		size, err := blobFile.IoManager.Size()
+		if err != nil {
+			return nil, err
+		}
+		blobFile.WriteOff = size
+		db.activeBlobFile = blobFile
+	}
+
+	var removed []*data.DataFile
+	for fid, blobFile := range db.blobFiles {
+		if !exists[fid] {
+			removed = append(removed, blobFile)
+		}
+	}
+	return removed, nil
+}
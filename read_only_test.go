@@ -0,0 +1,212 @@
+package bitcask_go
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	// 写入的进程还在使用数据目录时也可以只读打开
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.NotNil(t, ro)
+	assert.Equal(t, 1000, len(ro.ListKeys()))
+	val, err := ro.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(10), val)
+
+	// 所有的写操作都返回 ErrReadOnly
+	assert.Equal(t, ErrReadOnly, ro.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, ro.Delete(utils.GetTestKey(10)))
+	assert.Equal(t, ErrReadOnly, ro.Merge())
+	assert.Equal(t, ErrReadOnly, ro.BlobGC())
+	wb := ro.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("key"), []byte("value")))
+	assert.Equal(t, ErrReadOnly, wb.Commmit())
+
+	// 写入的进程新写入的数据在 Refresh 之后可见，包括新产生的数据文件和事务
+	activeFid := db.activeFile.FileId
+	for i := 1000; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	for i := 0; i < 100; i++ {
+		err := db.Delete(utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("value")))
+	assert.Nil(t, wb.Commmit())
+	assert.True(t, db.activeFile.FileId > activeFid)
+
+	assert.Equal(t, 1000, len(ro.ListKeys()))
+	err = ro.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 1901, len(ro.ListKeys()))
+	_, err = ro.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = ro.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// merge 之后文件被替换，重新加载所有的文件
+	for i := 100; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), []byte("new value"))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	err = db.Put([]byte("after merge"), []byte("value"))
+	assert.Nil(t, err)
+
+	err = ro.Refresh()
+	assert.Nil(t, err)
+	assert.Equal(t, 1902, len(ro.ListKeys()))
+	val, err = ro.Get(utils.GetTestKey(500))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	val, err = ro.Get([]byte("after merge"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// 关闭时不写入事务序列号
+	err = ro.Close()
+	assert.Nil(t, err)
+	_, err = os.Stat(filepath.Join(dir, data.SeqNumFileName))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_ReadOnly_NotExist(t *testing.T) {
+	opts := DefaultOptions
+	opts.DirPath = filepath.Join(os.TempDir(), "bitcask-go-read-only-not-exist")
+	opts.ReadOnly = true
+	db, err := Open(opts)
+	assert.NotNil(t, err)
+	assert.Nil(t, db)
+	_, err = os.Stat(opts.DirPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+// 只读模式下开启 MMapAtStartup 也不会以读写方式打开文件，不会在数据目录中创建文件
+func TestDB_ReadOnly_MMap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only-mmap")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		assert.Nil(t, os.Chmod(filepath.Join(dir, entry.Name()), 0444))
+	}
+	assert.Nil(t, os.Chmod(dir, 0555))
+	defer os.Chmod(dir, 0755)
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	roOpts.MMapAtStartup = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.NotNil(t, ro)
+	for i := 0; i < 1000; i++ {
+		val, err := ro.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+	assert.Nil(t, ro.Refresh())
+	assert.Nil(t, ro.Close())
+
+	after, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, len(entries), len(after))
+	assert.Nil(t, os.Chmod(dir, 0755))
+}
+
+// 写入的进程 merge 期间 Refresh 要么成功并读到一致的数据，要么返回 ErrMergeNotApplied
+func TestDB_ReadOnly_RefreshDuringMerge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-read-only-refresh-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.BackgroundIOBytesPerSec = 512 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	for i := 0; i < 3000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new value")))
+	}
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	ro, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.NotNil(t, ro)
+	defer ro.Close()
+
+	check := func() {
+		for _, i := range []int{0, 1500, 2999} {
+			val, err := ro.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("new value"), val)
+		}
+	}
+
+	mergeErr := make(chan error)
+	go func() {
+		mergeErr <- db.Merge()
+	}()
+	for done := false; !done; {
+		select {
+		case err := <-mergeErr:
+			assert.Nil(t, err)
+			done = true
+		default:
+		}
+		if err := ro.Refresh(); err != ErrMergeNotApplied {
+			assert.Nil(t, err)
+			check()
+		}
+	}
+
+	assert.Nil(t, ro.Refresh())
+	assert.Equal(t, db.nonMergeFileId, ro.nonMergeFileId)
+	check()
+}
@@ -0,0 +1,44 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+
+	"bitcask-go/data"
+)
+
+// 检查数据文件在最后一条有效记录之后是否还有数据
+// 活跃文件末尾的数据是写入过程中崩溃留下的，直接截断；旧的数据文件已经写满并持久化，
+// 出现损坏说明数据被破坏了，只有配置了 RepairCorruption 才截断损坏位置之后的数据
+func (db *DB) recoverDataFile(dataFile *data.DataFile, hints *fileHints) error {
+	// 密钥错误等不是数据损坏，不能截断
+	if hints.err != nil && hints.err != data.ErrInvalidCRC && hints.err != io.ErrUnexpectedEOF {
+		return hints.err
+	}
+
+	fileSize, err := dataFile.IoManager.Size()
+	if err != nil {
+		return err
+	}
+	if hints.err == nil && hints.size == fileSize {
+		return nil
+	}
+
+	// 只读模式下不能修改文件，活跃文件末尾可能是写入的进程正在写入的数据，只读取到最后一条有效的记录
+	if db.options.ReadOnly {
+		if dataFile == db.activeFile {
+			return nil
+		}
+		return ErrDataFileCorrupted
+	}
+
+	if dataFile != db.activeFile && !db.options.RepairCorruption {
+		return ErrDataFileCorrupted
+	}
+
+	if err := os.Truncate(data.GetDataFileName(db.options.DirPath, dataFile.FileId), int64(hints.size)); err != nil {
+		return err
+	}
+	db.truncatedSize += fileSize - hints.size
+	return nil
+}
@@ -0,0 +1,106 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+// 在数据文件末尾追加数据，模拟写入过程中崩溃
+func appendToFile(t *testing.T, fileName string, buf []byte) {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(buf)
+	assert.Nil(t, err)
+	_ = f.Close()
+}
+
+func TestDB_RecoverTornWrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	writeOff := db.activeFile.WriteOff
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 只写入了一半的记录
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{
+		Key:   logRecordKeyWithSeq([]byte("torn"), nonTransactionSeqNum),
+		Value: []byte("torn value"),
+	})
+	fileName := data.GetDataFileName(dir, 0)
+	appendToFile(t, fileName, encRecord[:len(encRecord)/2])
+
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(len(encRecord)/2), db2.Stat().TruncatedSize)
+	assert.Equal(t, writeOff, db2.activeFile.WriteOff)
+	assert.Equal(t, 100, len(db2.ListKeys()))
+	_, err = db2.Get([]byte("torn"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 截断之后继续写入
+	err = db2.Put([]byte("after"), []byte("value"))
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+
+	// 完整写入但校验失败的记录
+	encRecord[len(encRecord)-1] ^= 0xff
+	appendToFile(t, fileName, encRecord)
+
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Equal(t, uint64(len(encRecord)), db3.Stat().TruncatedSize)
+	assert.Equal(t, 101, len(db3.ListKeys()))
+	val, err := db3.Get([]byte("after"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+}
+
+func TestDB_RecoverSealedFile(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recover-sealed")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 2000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	assert.True(t, db.activeFile.FileId > 0)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 旧的数据文件损坏时打开失败
+	appendToFile(t, data.GetDataFileName(dir, 0), []byte("garbage"))
+	db2, err := Open(opts)
+	assert.Equal(t, ErrDataFileCorrupted, err)
+	assert.Nil(t, db2)
+
+	// 配置了 RepairCorruption 之后截断损坏的数据
+	opts.RepairCorruption = true
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	db = db3
+	assert.Equal(t, uint64(len("garbage")), db3.Stat().TruncatedSize)
+	assert.Equal(t, 2000, len(db3.ListKeys()))
+}
@@ -97,35 +97,30 @@ func (rds *RedisDataStructure) Get(key []byte) ([]byte, error) {
 //  data:     | key | version | field -> value
 
 func (rds *RedisDataStructure) HSet(key, field, value []byte) (bool, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, Hash)
-	if err != nil {
-		return false, err
-	}
-
-	// 构造 Hash 数据部分的 key
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
-	}
-	encKey := hk.encode()
-
-	// 先查找是否存在
-	var exist = true
-	if _, err = rds.db.Get(encKey); err == bitcask.ErrKeyNotFound {
-		exist = false
-	}
+	var exist bool
+	err := rds.update(key, Hash, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		// 构造 Hash 数据部分的 key
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
 
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	// 更新元数据
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
+		// 先查找是否存在
+		var err error
+		if exist, err = rds.exists(encKey); err != nil {
+			return false, err
+		}
 
-	wb.Put(encKey, value)
-	if err = wb.Commmit(); err != nil {
+		// 更新元数据
+		if !exist {
+			meta.size++
+		}
+		_ = wb.Put(encKey, value)
+		return true, nil
+	})
+	if err != nil {
 		return false, err
 	}
 
@@ -152,36 +147,33 @@ func (rds *RedisDataStructure) HGet(key, field []byte) ([]byte, error) {
 }
 
 func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Hash)
-	if err != nil {
-		return false, err
-	}
-	if meta.size == 0 {
-		return false, nil
-	}
+	var exist bool
+	err := rds.update(key, Hash, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		if meta.size == 0 {
+			exist = false
+			return false, nil
+		}
 
-	// 构造 Hash 数据部分的 key
-	hk := &hashInternalKey{
-		key:     key,
-		version: meta.version,
-		field:   field,
-	}
-	encKey := hk.encode()
+		// 构造 Hash 数据部分的 key
+		hk := &hashInternalKey{
+			key:     key,
+			version: meta.version,
+			field:   field,
+		}
+		encKey := hk.encode()
 
-	// 先查看是否存在
-	var exist = true
-	if _, err = rds.db.Get(encKey); err != nil {
-		exist = false
-	}
+		// 先查看是否存在
+		var err error
+		if exist, err = rds.exists(encKey); err != nil || !exist {
+			return false, err
+		}
 
-	if exist {
-		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 		meta.size--
-		_ = wb.Put(key, meta.encode())
 		_ = wb.Delete(encKey)
-		if err = wb.Commmit(); err != nil {
-			return false, err
-		}
+		return true, nil
+	})
+	if err != nil {
+		return false, err
 	}
 
 	return exist, nil
@@ -192,30 +184,29 @@ func (rds *RedisDataStructure) HDel(key, field []byte) (bool, error) {
 //  data:     | key | version | member | member size | -> NULL
 
 func (rds *RedisDataStructure) SAdd(key, member []byte) (bool, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, Set)
-	if err != nil {
-		return false, err
-	}
+	var ok bool
+	err := rds.update(key, Set, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		// 构造一个数据部分的 key
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
 
-	// 构造一个数据部分的 key
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
+		exist, err := rds.exists(sk.encode())
+		if err != nil || exist {
+			ok = false
+			return false, err
+		}
 
-	var ok bool
-	if _, err = rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
 		// 不存在的话则更新
-		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
 		meta.size++
-		_ = wb.Put(key, meta.encode())
 		_ = wb.Put(sk.encode(), nil)
-		if err = wb.Commmit(); err != nil {
-			return false, err
-		}
 		ok = true
+		return true, nil
+	})
+	if err != nil {
+		return false, err
 	}
 
 	return ok, nil
@@ -251,36 +242,37 @@ func (rds *RedisDataStructure) SIsMember(key, member []byte) (bool, error) {
 }
 
 func (rds *RedisDataStructure) SRem(key, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, Set)
-	if err != nil {
-		return false, err
-	}
-
-	if meta.size == 0 {
-		return false, nil
-	}
+	var ok bool
+	err := rds.update(key, Set, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		if meta.size == 0 {
+			ok = false
+			return false, nil
+		}
 
-	// 构造一个数据部分的 key
-	sk := &setInternalKey{
-		key:     key,
-		version: meta.version,
-		member:  member,
-	}
+		// 构造一个数据部分的 key
+		sk := &setInternalKey{
+			key:     key,
+			version: meta.version,
+			member:  member,
+		}
 
-	if _, err = rds.db.Get(sk.encode()); err == bitcask.ErrKeyNotFound {
-		return false, nil
-	}
+		exist, err := rds.exists(sk.encode())
+		if err != nil || !exist {
+			ok = false
+			return false, err
+		}
 
-	// 更新
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	meta.size--
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Delete(sk.encode())
-	if err = wb.Commmit(); err != nil {
+		// 更新
+		meta.size--
+		_ = wb.Delete(sk.encode())
+		ok = true
+		return true, nil
+	})
+	if err != nil {
 		return false, err
 	}
 
-	return true, nil
+	return ok, nil
 }
 
 // List
@@ -303,80 +295,75 @@ func (rds *RedisDataStructure) RPop(key []byte) ([]byte, error) {
 }
 
 func (rds *RedisDataStructure) pushInner(key, element []byte, isLeft bool) (uint32, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
-	if err != nil {
-		return 0, err
-	}
-
-	// 构造数据部分的 key
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
-	}
-	if isLeft {
-		lk.index = meta.head - 1
-	} else {
-		lk.index = meta.tail
-	}
+	var size uint32
+	err := rds.update(key, List, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		// 构造数据部分的 key
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		if isLeft {
+			lk.index = meta.head - 1
+		} else {
+			lk.index = meta.tail
+		}
 
-	// 更新元数据和数据部分
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	meta.size++
-	if isLeft {
-		meta.head--
-	} else {
-		meta.tail++
-	}
+		// 更新元数据和数据部分
+		meta.size++
+		if isLeft {
+			meta.head--
+		} else {
+			meta.tail++
+		}
 
-	_ = wb.Put(key, meta.encode())
-	_ = wb.Put(lk.encode(), element)
-	if err = wb.Commmit(); err != nil {
+		_ = wb.Put(lk.encode(), element)
+		size = meta.size
+		return true, nil
+	})
+	if err != nil {
 		return 0, err
 	}
 
-	return meta.size, nil
+	return size, nil
 }
 
 func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error) {
-	// 查找元数据
-	meta, err := rds.findMetadata(key, List)
-	if err != nil {
-		return nil, err
-	}
+	var element []byte
+	err := rds.update(key, List, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		if meta.size == 0 {
+			element = nil
+			return false, nil
+		}
 
-	if meta.size == 0 {
-		return nil, nil
-	}
+		// 构造数据部分的 key
+		lk := &listInternalKey{
+			key:     key,
+			version: meta.version,
+		}
+		if isLeft {
+			lk.index = meta.head
+		} else {
+			lk.index = meta.tail - 1
+		}
 
-	// 构造数据部分的 key
-	lk := &listInternalKey{
-		key:     key,
-		version: meta.version,
-	}
-	if isLeft {
-		lk.index = meta.head
-	} else {
-		lk.index = meta.tail - 1
-	}
+		var err error
+		if element, err = rds.db.Get(lk.encode()); err != nil {
+			return false, err
+		}
 
-	element, err := rds.db.Get(lk.encode())
+		// 更新元数据
+		meta.size--
+		if isLeft {
+			meta.head++
+		} else {
+			meta.tail--
+		}
+		return true, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// 更新元数据
-	meta.size--
-	if isLeft {
-		meta.head++
-	} else {
-		meta.tail--
-	}
-
-	if err = rds.db.Put(key, meta.encode()); err != nil {
-		return nil, err
-	}
-
 	return element, nil
 }
 
@@ -385,54 +372,51 @@ func (rds *RedisDataStructure) popInner(key []byte, isLeft bool) ([]byte, error)
 // data: 	 | key | version | member | -> | score |
 // data:     | key | version | score | member | member size | -> NULL
 func (rds *RedisDataStructure) ZAdd(key []byte, score float64, member []byte) (bool, error) {
-	meta, err := rds.findMetadata(key, ZSet)
-	if err != nil {
-		return false, err
-	}
-
-	// 构造数据部分的 key
-	zk := &zsetInternalKey{
-		key:     key,
-		version: meta.version,
-		score:   score,
-		member:  member,
-	}
+	var exist bool
+	err := rds.update(key, ZSet, func(meta *metadata, wb *bitcask.WriteBatch) (bool, error) {
+		// 构造数据部分的 key
+		zk := &zsetInternalKey{
+			key:     key,
+			version: meta.version,
+			score:   score,
+			member:  member,
+		}
 
-	// 查看是否已经存在
-	var exist bool = true
-	value, err := rds.db.Get(zk.encodeWithMember())
-	if err != nil && err != bitcask.ErrKeyNotFound {
-		return false, err
-	}
-	if err == bitcask.ErrKeyNotFound {
-		exist = false
-	}
+		// 查看是否已经存在
+		exist = true
+		value, err := rds.db.Get(zk.encodeWithMember())
+		if err != nil && err != bitcask.ErrKeyNotFound {
+			return false, err
+		}
+		if err == bitcask.ErrKeyNotFound {
+			exist = false
+		}
 
-	if exist {
-		if score == utils.Float64FromBytes(value) {
-			return false, nil
+		if exist {
+			if score == utils.Float64FromBytes(value) {
+				return false, nil
+			}
 		}
-	}
 
-	// 更新元数据和数据
-	wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
-	if !exist {
-		meta.size++
-		_ = wb.Put(key, meta.encode())
-	}
-	if exist {
-		oldKey := &zsetInternalKey{
-			key:     key,
-			version: meta.version,
-			member:  member,
-			score:   utils.Float64FromBytes(value),
+		// 更新元数据和数据
+		if !exist {
+			meta.size++
+		}
+		if exist {
+			oldKey := &zsetInternalKey{
+				key:     key,
+				version: meta.version,
+				member:  member,
+				score:   utils.Float64FromBytes(value),
+			}
+			_ = wb.Delete(oldKey.encodeWithScore())
 		}
-		_ = wb.Delete(oldKey.encodeWithScore())
-	}
 
-	_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
-	_ = wb.Put(zk.encodeWithScore(), nil)
-	if err = wb.Commmit(); err != nil {
+		_ = wb.Put(zk.encodeWithMember(), utils.Float64ToBytes(score))
+		_ = wb.Put(zk.encodeWithScore(), nil)
+		return true, nil
+	})
+	if err != nil {
 		return false, err
 	}
 
@@ -465,9 +449,15 @@ func (rds *RedisDataStructure) ZScore(key []byte, member []byte) (float64, error
 }
 
 func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType) (*metadata, error) {
+	meta, _, err := rds.loadMetadata(key, dataType)
+	return meta, err
+}
+
+// 查找元数据，同时返回编码之后的元数据，不存在时为 nil
+func (rds *RedisDataStructure) loadMetadata(key []byte, dataType redisDataType) (*metadata, []byte, error) {
 	metaBuf, err := rds.db.Get(key)
 	if err != nil && err != bitcask.ErrKeyNotFound {
-		return nil, err
+		return nil, nil, err
 	}
 
 	var meta *metadata
@@ -478,7 +468,7 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType)
 		meta = decodeMetadata(metaBuf)
 		// 判断数据类型
 		if meta.dataType != dataType {
-			return nil, ErrWrongTypeOperation
+			return nil, nil, ErrWrongTypeOperation
 		}
 		// 判断过期时间
 		if meta.expire != 0 && meta.expire <= uint64(time.Now().UnixNano()) {
@@ -499,5 +489,40 @@ func (rds *RedisDataStructure) findMetadata(key []byte, dataType redisDataType)
 			meta.tail = initialListMark
 		}
 	}
-	return meta, nil
+	return meta, metaBuf, nil
+}
+
+// 读取元数据之后由 fn 在 WriteBatch 中暂存数据部分的修改并更新元数据，fn 返回 false 表示不需要写入
+// 元数据和数据部分一起提交，提交时元数据必须没有被其他写入修改过，否则重新读取之后重试，
+// 因此同一个 key 上并发的修改不会互相覆盖
+func (rds *RedisDataStructure) update(key []byte, dataType redisDataType, fn func(meta *metadata, wb *bitcask.WriteBatch) (bool, error)) error {
+	for {
+		meta, oldMeta, err := rds.loadMetadata(key, dataType)
+		if err != nil {
+			return err
+		}
+
+		wb := rds.db.NewWriteBatch(bitcask.DefaultWriteBatchOptions)
+		if ok, err := fn(meta, wb); err != nil || !ok {
+			return err
+		}
+		if oldMeta == nil {
+			_ = wb.PutIfAbsent(key, meta.encode())
+		} else {
+			_ = wb.CompareAndSwap(key, oldMeta, meta.encode())
+		}
+
+		if err = wb.Commmit(); err != bitcask.ErrPreconditionFailed {
+			return err
+		}
+	}
+}
+
+// 数据部分的 key 是否存在
+func (rds *RedisDataStructure) exists(key []byte) (bool, error) {
+	_, err := rds.db.Get(key)
+	if err == bitcask.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
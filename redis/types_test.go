@@ -2,6 +2,7 @@ package redis
 
 import (
 	"os"
+	"sync"
 	"testing"
 	"time"
 
@@ -254,3 +255,51 @@ func TestRedisDataStructure_ZScore(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, float64(98), score)
 }
+
+// 并发修改同一个 key 时元数据不会互相覆盖
+func TestRedisDataStructure_Concurrent(t *testing.T) {
+	closer := openRedisDB()
+	defer closer()
+
+	wg := new(sync.WaitGroup)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				member := utils.GetTestKey(g*50 + i)
+				ok, err := rds.SAdd([]byte("set"), member)
+				assert.Nil(t, err)
+				assert.True(t, ok)
+				ok, err = rds.HSet([]byte("hash"), member, []byte("value"))
+				assert.Nil(t, err)
+				assert.True(t, ok)
+				_, err = rds.RPush([]byte("list"), member)
+				assert.Nil(t, err)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for _, key := range []string{"set", "hash"} {
+		typ := Set
+		if key == "hash" {
+			typ = Hash
+		}
+		meta, err := rds.findMetadata([]byte(key), typ)
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(400), meta.size)
+	}
+
+	// 每个元素都可以弹出，没有被覆盖
+	popped := make(map[string]bool)
+	for i := 0; i < 400; i++ {
+		element, err := rds.LPop([]byte("list"))
+		assert.Nil(t, err)
+		popped[string(element)] = true
+	}
+	assert.Equal(t, 400, len(popped))
+	element, err := rds.LPop([]byte("list"))
+	assert.Nil(t, err)
+	assert.Nil(t, element)
+}
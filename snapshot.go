@@ -0,0 +1,107 @@
+package bitcask_go
+
+import (
+	"time"
+
+	"bitcask-go/data"
+	"bitcask-go/index"
+)
+
+// Snapshot 数据库在某一时刻的只读视图
+// 快照冻结了创建时刻的内存索引和数据文件，之后的 Put/Delete/WriteBatch 对其不可见
+// merge 替换掉的旧文件在快照关闭之前不会被关闭
+type Snapshot struct {
+	db     *DB
+	index  index.Indexer // 创建快照时刻的索引
+	view   *fileView     // 创建快照时刻的数据文件
+	now    int64         // 创建快照的时间，用于判断 key 是否过期
+	closed bool
+}
+
+// NewSnapshot 创建快照，使用完之后需要调用 Close 释放资源
+// B+ 树索引的快照持有索引文件的只读事务，关闭之前索引文件无法扩容，需要尽快关闭
+func (db *DB) NewSnapshot() (*Snapshot, error) {
+	// 持有锁，保证 WriteBatch 的提交要么全部可见，要么全部不可见
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	indexSnapshot, err := db.index.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		db:    db,
+		index: indexSnapshot,
+		view:  db.newFileView(),
+		now:   time.Now().UnixNano(),
+	}, nil
+}
+
+// Get 根据 key 读取快照中的数据
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	if s.closed {
+		return nil, ErrSnapshotClosed
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired(s.now) {
+		return nil, ErrKeyNotFound
+	}
+
+	return s.getValuesByPosition(logRecordPos)
+}
+
+// NewIterator 初始化快照上的迭代器
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	iterator := &Iterator{
+		indexIter: s.index.Iterator(opts.Reverse),
+		db:        s.db,
+		snapshot:  s,
+		options:   opts,
+	}
+	iterator.start()
+	return iterator
+}
+
+// Fold 获取快照中的所有数据，并执行用户指定的操作，函数返回 false 时终止操作
+func (s *Snapshot) Fold(fn func(key []byte, value []byte) bool) error {
+	if s.closed {
+		return ErrSnapshotClosed
+	}
+
+	iterator := s.index.Iterator(false)
+	defer iterator.Close()
+
+	for iterator.Rewind(); iterator.Valid(); iterator.Next() {
+		if iterator.Value().IsExpired(s.now) {
+			continue
+		}
+		value, err := s.getValuesByPosition(iterator.Value())
+		if err != nil {
+			return err
+		}
+
+		if !fn(iterator.Key(), value) {
+			break
+		}
+	}
+	return nil
+}
+
+// Close 释放快照持有的资源
+func (s *Snapshot) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.db.releaseFileView(s.view)
+	return s.index.Close()
+}
+
+// 从快照持有的数据文件中读取 value
+func (s *Snapshot) getValuesByPosition(logRecordPos *data.LogRecordPos) ([]byte, error) {
+	return s.view.getValuesByPosition(logRecordPos)
+}
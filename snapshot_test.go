@@ -0,0 +1,85 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func TestDB_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+
+	snap, err := db.NewSnapshot()
+	assert.Nil(t, err)
+	defer snap.Close()
+
+	// 快照之后的修改对快照不可见
+	err = db.Put(utils.GetTestKey(1), []byte("new value"))
+	assert.Nil(t, err)
+	err = db.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(1000), []byte("new key"))
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	_ = wb.Put(utils.GetTestKey(3), []byte("batch value"))
+	err = wb.Commmit()
+	assert.Nil(t, err)
+
+	val, err := snap.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	val, err = snap.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(2), val)
+	val, err = snap.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(3), val)
+	_, err = snap.Get(utils.GetTestKey(1000))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 迭代器和 Fold
+	iter := snap.NewIterator(DefaultIteratorOptions)
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		val, err := iter.Value()
+		assert.Nil(t, err)
+		assert.Equal(t, iter.Key(), val)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 100, count)
+
+	count = 0
+	err = snap.Fold(func(key []byte, value []byte) bool {
+		assert.Equal(t, key, value)
+		count++
+		return true
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 100, count)
+
+	// 数据库本身可以看到最新的数据
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+
+	// 关闭之后不可用
+	err = snap.Close()
+	assert.Nil(t, err)
+	_, err = snap.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrSnapshotClosed, err)
+}
@@ -0,0 +1,176 @@
+package bitcask_go
+
+import (
+	"sync"
+	"time"
+
+	"bitcask-go/data"
+)
+
+// Txn 交互式乐观事务
+// 读取时直接读取已经提交的数据，并且可以读到自己尚未提交的写入
+// 提交时如果读过的 key 已经被其他提交修改，则返回 ErrTxnConflict，因此提交成功的事务读到的数据在提交时依然有效
+// 读取之后执行过 Merge 或者 MergeFiles 时数据的位置被重写，无法判断是否被修改过，同样返回 ErrTxnConflict
+type Txn struct {
+	mu            *sync.Mutex
+	db            *DB
+	pendingWrites map[string]*data.LogRecord    // 暂存用户写入的数据
+	readSet       map[string]*data.LogRecordPos // 读过的 key 以及读到的位置索引，key 不存在时为 nil
+	readGen       uint64                        // 第一次读取时数据文件被重写的次数，位置索引只在同一代中可以比较
+	closed        bool
+}
+
+// Begin 开启一个事务，使用完之后需要调用 Commit 或者 Rollback
+func (db *DB) Begin() *Txn {
+	// 和 WriteBatch 一样依赖事务序列号
+	if db.options.IndexType == BPlusTree && !db.seqNumFileExists && !db.isInitial {
+		panic("cannot use transaction, seq no file no exists")
+	}
+	return &Txn{
+		mu:            new(sync.Mutex),
+		db:            db,
+		pendingWrites: make(map[string]*data.LogRecord),
+		readSet:       make(map[string]*data.LogRecordPos),
+	}
+}
+
+// Get 读取数据，优先读取事务内尚未提交的写入
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return nil, ErrTxnClosed
+	}
+
+	// 读自己的写
+	if record := txn.pendingWrites[string(key)]; record != nil {
+		if record.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return record.Value, nil
+	}
+
+	// 读取已经提交的数据，并记录到读集合中
+	txn.db.mu.RLock()
+	defer txn.db.mu.RUnlock()
+
+	logRecordPos := txn.db.index.Get(key)
+	if _, ok := txn.readSet[string(key)]; !ok {
+		if len(txn.readSet) == 0 {
+			txn.readGen = txn.db.fileGeneration
+		}
+		txn.readSet[string(key)] = logRecordPos
+	}
+
+	if logRecordPos == nil || logRecordPos.IsExpired(time.Now().UnixNano()) {
+		return nil, ErrKeyNotFound
+	}
+	return txn.db.getValuesByPosition(logRecordPos)
+}
+
+// Put 暂存写入的数据
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Value: value}
+	return nil
+}
+
+// Delete 暂存删除操作
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{Key: key, Type: data.LogRecordDeleted}
+	return nil
+}
+
+// Commit 校验读集合，并将暂存的数据写到数据文件
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return ErrTxnClosed
+	}
+	defer txn.close()
+
+	// 只读事务不需要校验
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+	if txn.db.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	// 校验在写入时的互斥锁中进行，保证校验和提交的原子性，组内之前提交的写入同样会导致冲突
+	return txn.db.commitPendingWrites(txn.db.options.SyncWrites, func() (map[string]*data.LogRecord, error) {
+		// merge 之后的位置和之前的位置不能比较，文件 id 会被重新使用
+		if len(txn.readSet) > 0 && txn.readGen != txn.db.fileGeneration {
+			return nil, ErrTxnConflict
+		}
+
+		// 读过的 key 的位置发生了变化，说明被其他提交修改过
+		for key, readPos := range txn.readSet {
+			if !isSamePos(readPos, txn.db.currentPos([]byte(key))) {
+				return nil, ErrTxnConflict
+			}
+		}
+
+		// 删除不存在的 key 不需要写入数据文件
+		pendingWrites := make(map[string]*data.LogRecord, len(txn.pendingWrites))
+		for key, record := range txn.pendingWrites {
+			if record.Type == data.LogRecordDeleted && txn.db.currentPos(record.Key) == nil {
+				continue
+			}
+			pendingWrites[key] = record
+		}
+		return pendingWrites, nil
+	})
+}
+
+// Rollback 放弃事务中暂存的数据
+func (txn *Txn) Rollback() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.closed {
+		return nil
+	}
+	txn.close()
+	return nil
+}
+
+// 释放事务暂存的数据
+func (txn *Txn) close() {
+	txn.closed = true
+	txn.pendingWrites = nil
+	txn.readSet = nil
+}
+
+// 判断两个位置索引是否指向同一条数据
+func isSamePos(a, b *data.LogRecordPos) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Fid == b.Fid && a.Offset == b.Offset
+}
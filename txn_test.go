@@ -0,0 +1,204 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+// 读自己的写，提交之后对 DB 可见
+func TestDB_Txn1(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	txn := db.Begin()
+	val, err := txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	err = txn.Put(utils.GetTestKey(1), []byte("v2"))
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("v3"))
+	assert.Nil(t, err)
+	val, err = txn.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+
+	err = txn.Delete(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	_, err = txn.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 提交之前对 DB 不可见
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+
+	err = txn.Commit()
+	assert.Nil(t, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnClosed, err)
+
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// 重启之后依然可见
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	assert.Equal(t, uint64(1), db2.seqNum)
+}
+
+// 读过的 key 被其他提交修改，产生冲突
+func TestDB_Txn2(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("100"))
+	assert.Nil(t, err)
+
+	txn1 := db.Begin()
+	txn2 := db.Begin()
+
+	_, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	_, err = txn2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	// 读一个不存在的 key
+	_, err = txn2.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	err = txn1.Put(utils.GetTestKey(1), []byte("90"))
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(1), []byte("80"))
+	assert.Nil(t, err)
+
+	err = txn1.Commit()
+	assert.Nil(t, err)
+	err = txn2.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("90"), val)
+
+	// 读过的 key 被其他提交新建
+	txn3 := db.Begin()
+	_, err = txn3.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+	err = txn3.Put(utils.GetTestKey(3), []byte("30"))
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(2), []byte("20"))
+	assert.Nil(t, err)
+	err = txn3.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+
+	// 回滚之后数据不可见
+	txn4 := db.Begin()
+	err = txn4.Put(utils.GetTestKey(4), []byte("40"))
+	assert.Nil(t, err)
+	err = txn4.Rollback()
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+// 事务期间执行 merge，读过数据的事务返回冲突，只写入的事务和 merge 之后才读取的事务正常提交
+func TestDB_Txn_Merge(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-merge")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// merge 之后 key 的新位置可能和读取时的位置相同，但是 key 已经被修改过
+	assert.Nil(t, db.Put([]byte("aba"), []byte("v1")))
+	txn0 := db.Begin()
+	val, err := txn0.Get([]byte("aba"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v1"), val)
+	assert.Nil(t, txn0.Put([]byte("aba"), []byte("txn0")))
+	assert.Nil(t, db.Put([]byte("aba"), []byte("v2")))
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("old")))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("new")))
+	}
+
+	txn1 := db.Begin()
+	val, err = txn1.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	assert.Nil(t, txn1.Put(utils.GetTestKey(1), []byte("txn1")))
+
+	txn2 := db.Begin()
+	assert.Nil(t, txn2.Put(utils.GetTestKey(2), []byte("txn2")))
+
+	// 开始之后其他提交的写入对之后的读取可见
+	txn3 := db.Begin()
+	assert.Nil(t, db.Put(utils.GetTestKey(3), []byte("put")))
+
+	assert.Nil(t, db.Merge())
+
+	assert.Equal(t, ErrTxnConflict, txn0.Commit())
+	assert.Equal(t, ErrTxnConflict, txn1.Commit())
+	assert.Nil(t, txn2.Commit())
+	val, err = txn3.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("put"), val)
+	assert.Nil(t, txn3.Put(utils.GetTestKey(3), []byte("txn3")))
+	assert.Nil(t, txn3.Commit())
+
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn2"), val)
+	val, err = db.Get(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("txn3"), val)
+
+	// MergeFiles 同样会重写位置
+	txn4 := db.Begin()
+	_, err = txn4.Get(utils.GetTestKey(4))
+	assert.Nil(t, err)
+	assert.Nil(t, txn4.Put(utils.GetTestKey(4), []byte("txn4")))
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i+1000), []byte("value")))
+	}
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i+1000)))
+	}
+	assert.Nil(t, db.MergeFiles(MergePolicy{GarbageRatio: 0.5}))
+	assert.Equal(t, ErrTxnConflict, txn4.Commit())
+}
@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// 一次最多等待的时长，等待期间修改了速率可以尽快生效
+const maxRateLimitWait = 100 * time.Millisecond
+
+// RateLimiter 令牌桶限速，限制每秒读写的字节数，桶的容量为一秒的令牌
+// 为 nil 或者速率为 0 时不限速，速率可以在运行时修改
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   uint64  // 每秒的字节数
+	tokens float64 // 当前可用的令牌
+	last   time.Time
+}
+
+func NewRateLimiter(bytesPerSec uint64) *RateLimiter {
+	return &RateLimiter{rate: bytesPerSec, last: time.Now()}
+}
+
+// SetRate 修改每秒的字节数，0 表示不限速，正在等待的调用按照新的速率继续
+func (l *RateLimiter) SetRate(bytesPerSec uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = bytesPerSec
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+}
+
+// Rate 当前每秒的字节数
+func (l *RateLimiter) Rate() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// Wait 等待直到可以读写 n 个字节
+func (l *RateLimiter) Wait(n int) {
+	if l == nil {
+		return
+	}
+	remaining := float64(n)
+	for remaining > 0 {
+		l.mu.Lock()
+		if l.rate == 0 {
+			l.mu.Unlock()
+			return
+		}
+		l.refill(time.Now())
+		if l.tokens > 0 {
+			take := l.tokens
+			if take > remaining {
+				take = remaining
+			}
+			l.tokens -= take
+			remaining -= take
+		}
+		wait := time.Duration(remaining / float64(l.rate) * float64(time.Second))
+		l.mu.Unlock()
+
+		if wait > maxRateLimitWait {
+			wait = maxRateLimitWait
+		}
+		if remaining > 0 {
+			time.Sleep(wait)
+		}
+	}
+}
+
+// 按照经过的时间补充令牌
+// 在访问此方法前必须持有 l.mu
+func (l *RateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+}
+
+// Reader 返回限速读取的 Reader，l 为 nil 时直接返回 r
+func (l *RateLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, limiter: l}
+}
+
+type rateLimitedReader struct {
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.limiter.Wait(n)
+	return n, err
+}
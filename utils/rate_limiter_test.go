@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter_Wait(t *testing.T) {
+	limiter := NewRateLimiter(100 * 1024)
+	start := time.Now()
+	limiter.Wait(50 * 1024)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+
+	// 不限速时直接返回
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(1 << 30)
+	limiter.SetRate(0)
+	start = time.Now()
+	limiter.Wait(1 << 30)
+	assert.True(t, time.Since(start) < 50*time.Millisecond)
+}
+
+func TestRateLimiter_SetRate(t *testing.T) {
+	limiter := NewRateLimiter(1024)
+	done := make(chan struct{})
+	go func() {
+		limiter.Wait(1024 * 1024)
+		close(done)
+	}()
+
+	// 等待期间提高速率之后尽快返回
+	time.Sleep(50 * time.Millisecond)
+	limiter.SetRate(0)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("wait does not return after the rate is lifted")
+	}
+	assert.Equal(t, uint64(0), limiter.Rate())
+}
+
+func TestRateLimiter_Reader(t *testing.T) {
+	limiter := NewRateLimiter(200 * 1024)
+	value := bytes.Repeat([]byte("a"), 100*1024)
+	start := time.Now()
+	buf, err := io.ReadAll(limiter.Reader(bytes.NewReader(value)))
+	assert.Nil(t, err)
+	assert.Equal(t, value, buf)
+	assert.True(t, time.Since(start) >= 400*time.Millisecond)
+}
@@ -0,0 +1,130 @@
+package bitcask_go
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+
+	"bitcask-go/data"
+)
+
+// 每个缓存项除了 value 之外额外占用的内存
+const valueCacheEntryOverhead = 64
+
+// 读取 value 的 LRU 缓存，容量按照字节计算
+// 以文件和偏移作为 key，merge 之后同一个 id 的新文件是不同的 DataFile，不会读到旧文件的缓存
+type valueCache struct {
+	mu       sync.Mutex
+	capacity uint64
+	size     uint64
+	lru      *list.List // 最近使用的在最前面
+	entries  map[valueCacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+type valueCacheKey struct {
+	file   *data.DataFile
+	offset uint64
+}
+
+type valueCacheEntry struct {
+	key   valueCacheKey
+	value []byte
+}
+
+func newValueCache(capacity uint64) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[valueCacheKey]*list.Element),
+	}
+}
+
+// 读取 value，缓存中没有时从文件中读取并放入缓存，cache 为 nil 时直接读取文件
+func (c *valueCache) read(dataFile *data.DataFile, logRecordPos *data.LogRecordPos) ([]byte, error) {
+	if c == nil || dataFile == nil {
+		return readValueFromDataFile(dataFile, logRecordPos)
+	}
+
+	key := valueCacheKey{file: dataFile, offset: logRecordPos.Offset}
+	if value, ok := c.get(key); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return value, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	value, err := readValueFromDataFile(dataFile, logRecordPos)
+	if err != nil {
+		return nil, err
+	}
+	c.put(key, value)
+	return value, nil
+}
+
+// 调用方可能修改返回的 value，返回一份拷贝
+func (c *valueCache) get(key valueCacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return append([]byte{}, elem.Value.(*valueCacheEntry).value...), true
+}
+
+func (c *valueCache) put(key valueCacheKey, value []byte) {
+	entrySize := uint64(len(value)) + valueCacheEntryOverhead
+	if entrySize > c.capacity {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	entry := &valueCacheEntry{key: key, value: append([]byte{}, value...)}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entrySize
+
+	// 淘汰最久没有使用的缓存项
+	for c.size > c.capacity {
+		c.removeElement(c.lru.Back())
+	}
+}
+
+// 删除被替换或者删除的文件的缓存
+func (c *valueCache) removeFiles(files []*data.DataFile) {
+	if c == nil || len(files) == 0 {
+		return
+	}
+	removed := make(map[*data.DataFile]bool, len(files))
+	for _, file := range files {
+		removed[file] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.entries {
+		if removed[key.file] {
+			c.removeElement(elem)
+		}
+	}
+}
+
+// 在访问此方法前必须持有 c.mu
+func (c *valueCache) removeElement(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*valueCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= uint64(len(entry.value)) + valueCacheEntryOverhead
+}
+
+// 缓存命中和未命中的次数
+func (c *valueCache) stat() (uint64, uint64) {
+	if c == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&c.hits), atomic.LoadUint64(&c.misses)
+}
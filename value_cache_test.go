@@ -0,0 +1,101 @@
+package bitcask_go
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/utils"
+)
+
+func TestDB_ValueCache(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-cache")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueCacheSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	// 第一次读取未命中，之后命中
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	stat := db.Stat()
+	assert.Equal(t, uint64(0), stat.ValueCacheHits)
+	assert.Equal(t, uint64(1), stat.ValueCacheMisses)
+
+	// 修改返回的 value 不影响缓存
+	val[0] = 'x'
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	assert.Equal(t, uint64(1), db.Stat().ValueCacheHits)
+
+	// 删除和覆盖之后读取到的是新的数据
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("new value")))
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new value"), val)
+	assert.Nil(t, db.Delete(utils.GetTestKey(2)))
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// merge 之后文件 id 被重用，被替换的文件的缓存失效
+	for i := 0; i < 1000; i++ {
+		_, err := db.Get(utils.GetTestKey(i))
+		if i != 2 {
+			assert.Nil(t, err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Merge())
+	for key := range db.valueCache.entries {
+		assert.True(t, key.file == db.activeFile || db.olderFiles[key.file.FileId] == key.file)
+	}
+	for i := 500; i < 1000; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+func TestValueCache_Evict(t *testing.T) {
+	cache := newValueCache(3 * (valueCacheEntryOverhead + 10))
+	file := &data.DataFile{}
+	for i := 0; i < 4; i++ {
+		cache.put(valueCacheKey{file: file, offset: uint64(i)}, make([]byte, 10))
+	}
+	assert.Equal(t, 3, len(cache.entries))
+	assert.Equal(t, uint64(3*(valueCacheEntryOverhead+10)), cache.size)
+
+	// 最久没有使用的被淘汰
+	_, ok := cache.get(valueCacheKey{file: file, offset: 0})
+	assert.False(t, ok)
+	_, ok = cache.get(valueCacheKey{file: file, offset: 1})
+	assert.True(t, ok)
+	cache.put(valueCacheKey{file: file, offset: 4}, make([]byte, 10))
+	_, ok = cache.get(valueCacheKey{file: file, offset: 1})
+	assert.True(t, ok)
+	_, ok = cache.get(valueCacheKey{file: file, offset: 2})
+	assert.False(t, ok)
+
+	// 超过容量的 value 不缓存
+	cache.put(valueCacheKey{file: file, offset: 5}, make([]byte, 1024))
+	assert.Equal(t, 3, len(cache.entries))
+
+	cache.removeFiles([]*data.DataFile{file})
+	assert.Equal(t, 0, len(cache.entries))
+	assert.Equal(t, uint64(0), cache.size)
+}
@@ -0,0 +1,475 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofrs/flock"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+)
+
+// VerifyReport 数据目录的校验结果
+type VerifyReport struct {
+	Files    int             // 检查的文件数量
+	Records  int             // 校验通过的记录数量
+	Keys     int             // 有效的 key 数量
+	Problems []VerifyProblem // 发现的问题
+}
+
+// OK 是否没有发现任何问题
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyProblem 校验发现的一个问题
+type VerifyProblem struct {
+	File   string // 文件名
+	Offset uint64 // 问题记录在文件中的位置
+	Err    error
+}
+
+func (p VerifyProblem) String() string {
+	return fmt.Sprintf("%s at offset %d: %v", p.File, p.Offset, p.Err)
+}
+
+// Verify 离线校验数据目录中的所有文件，keys 是数据加密使用过的密钥，数据没有加密时不需要传入
+// 检查每条记录的 CRC、末尾不完整的记录、没有事务完成标识的事务记录，以及 hint 文件中指向无效位置的索引
+func Verify(dir string, keys ...[]byte) (*VerifyReport, error) {
+	v, err := openVerifier(dir, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer v.close()
+
+	if err := v.verify(); err != nil {
+		return nil, err
+	}
+	return v.report, nil
+}
+
+// Repair 校验 dir 中的数据，并将所有有效的数据按照 options 重新写到 options.DirPath 中，options.DirPath 必须不存在或者为空
+// 损坏的记录、未完成的事务以及之后的数据都会被丢弃，读取时使用 options 中的所有密钥，新的目录使用 EncryptionKey 加密
+func Repair(dir string, options Options) (*VerifyReport, error) {
+	if entries, err := os.ReadDir(options.DirPath); err == nil && len(entries) > 0 {
+		return nil, ErrRepairDirNotEmpty
+	}
+
+	v, err := openVerifier(dir, append([][]byte{options.EncryptionKey}, options.PreviousEncryptionKeys...))
+	if err != nil {
+		return nil, err
+	}
+	defer v.close()
+
+	if err := v.verify(); err != nil {
+		return nil, err
+	}
+
+	// 写入期间不能自动 merge
+	options.AutoMergeInterval, options.AutoMergeWrites = 0, 0
+	target, err := Open(options)
+	if err != nil {
+		return nil, err
+	}
+	for key, pos := range v.index {
+		logRecord, err := v.readRecord(pos)
+		if err != nil {
+			continue
+		}
+		newPos, err := target.appendLogRecord(&data.LogRecord{
+			Key:    logRecordKeyWithSeq([]byte(key), nonTransactionSeqNum),
+			Value:  logRecord.Value,
+			Expire: pos.Expire,
+		})
+		if err != nil {
+			_ = target.Close()
+			return nil, err
+		}
+		target.index.Put([]byte(key), newPos)
+	}
+	if err := target.Sync(); err != nil {
+		_ = target.Close()
+		return nil, err
+	}
+	return v.report, target.Close()
+}
+
+// 离线校验数据目录
+type verifier struct {
+	dir       string
+	codec     *data.Codec
+	fileLock  *flock.Flock
+	dataFiles map[uint32]*data.DataFile
+	blobFiles map[uint32]*data.DataFile
+	index     map[string]*data.LogRecordPos // 校验通过的有效数据
+	report    *VerifyReport
+}
+
+func openVerifier(dir string, keys [][]byte) (*verifier, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	var key []byte
+	var previousKeys [][]byte
+	if len(keys) > 0 {
+		key, previousKeys = keys[0], keys[1:]
+	}
+	codec, err := data.NewCodec(DefaultOptions.Compression, key, previousKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	// 校验期间不允许其他进程写入，只读取数据目录，不创建锁文件
+	// 锁文件不存在说明没有写入的进程打开过这个目录，不需要加锁
+	var fileLock *flock.Flock
+	if _, err := os.Stat(filepath.Join(dir, fileLockName)); err == nil {
+		fileLock = flock.New(filepath.Join(dir, fileLockName))
+		hold, err := fileLock.TryRLock()
+		if err != nil {
+			return nil, err
+		}
+		if !hold {
+			return nil, ErrDatabaseIsUsing
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return &verifier{
+		dir:       dir,
+		codec:     codec,
+		fileLock:  fileLock,
+		dataFiles: make(map[uint32]*data.DataFile),
+		blobFiles: make(map[uint32]*data.DataFile),
+		index:     make(map[string]*data.LogRecordPos),
+		report:    &VerifyReport{},
+	}, nil
+}
+
+func (v *verifier) close() {
+	for _, file := range v.dataFiles {
+		_ = file.Close()
+	}
+	for _, file := range v.blobFiles {
+		_ = file.Close()
+	}
+	if v.fileLock != nil {
+		_ = v.fileLock.Unlock()
+	}
+}
+
+func (v *verifier) addProblem(file string, offset uint64, err error) {
+	v.report.Problems = append(v.report.Problems, VerifyProblem{File: file, Offset: offset, Err: err})
+}
+
+func (v *verifier) verify() error {
+	dataFileIds, err := v.listFiles(data.DataFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	blobFileIds, err := v.listFiles(data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+	hintFileIds, err := v.listFiles(data.HintFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, fid := range dataFileIds {
+		dataFile, err := data.OpenDataFile(v.dir, fid, fio.ReadOnlyFIO)
+		if err != nil {
+			return err
+		}
+		dataFile.Codec = v.codec
+		v.dataFiles[fid] = dataFile
+	}
+	for _, fid := range blobFileIds {
+		blobFile, err := data.OpenBlobFile(v.dir, fid, fio.ReadOnlyFIO)
+		if err != nil {
+			return err
+		}
+		blobFile.Codec = v.codec
+		v.blobFiles[fid] = blobFile
+	}
+
+	// 按照文件 id 的顺序重放数据文件中的记录，得到有效的数据
+	if err := v.verifyDataFiles(dataFileIds); err != nil {
+		return err
+	}
+	for _, fid := range blobFileIds {
+		if err := v.walkFile(v.blobFiles[fid], filepath.Base(data.GetBlobFileName(v.dir, fid)), nil); err != nil {
+			return err
+		}
+	}
+
+	// hint 文件中的索引必须指向数据文件中对应的记录，被 MergeFiles 重写过的文件不再使用 hint 索引文件中的位置
+	_, _, compactedFiles, err := readCompactFinishedFile(v.dir, v.codec, fio.ReadOnlyFIO)
+	if err != nil {
+		v.addProblem(data.CompactFinishedFileName, 0, err)
+	}
+	if err := v.verifyHintFile(data.HintFileName, func(dirPath string) (*data.DataFile, error) {
+		return data.OpenHintFile(dirPath, fio.ReadOnlyFIO)
+	}, false, compactedFiles); err != nil {
+		return err
+	}
+	for _, fid := range hintFileIds {
+		fid := fid
+		if err := v.verifyHintFile(filepath.Base(data.GetHintFileName(v.dir, fid)), func(dirPath string) (*data.DataFile, error) {
+			return data.OpenDataHintFile(dirPath, fid, fio.ReadOnlyFIO)
+		}, true, nil); err != nil {
+			return err
+		}
+	}
+
+	if err := v.verifyMetaFile(data.SeqNumFileName, seqNumKey, data.OpenSeqNumFile); err != nil {
+		return err
+	}
+	if err := v.verifyMetaFile(data.MergeFinishedFileName, mergeFinishedKey, func(dirPath string) (*data.DataFile, error) {
+		return data.OpenMergeFinishedFile(dirPath, fio.ReadOnlyFIO)
+	}); err != nil {
+		return err
+	}
+	if err := v.verifyMetaFile(data.CompactFinishedFileName, compactFileIdKey, func(dirPath string) (*data.DataFile, error) {
+		return data.OpenCompactFinishedFile(dirPath, fio.ReadOnlyFIO)
+	}); err != nil {
+		return err
+	}
+
+	if m, err := readManifest(v.dir); err != nil && !os.IsNotExist(err) {
+		v.addProblem(manifestFileName, 0, err)
+	} else if err == nil && m.FormatVersion > manifestFormatVersion {
+		v.addProblem(manifestFileName, 0, ErrUnsupportedFormat)
+	}
+
+	// 指向 blob 文件的数据需要能够读取到 value
+	now := time.Now().UnixNano()
+	for key, pos := range v.index {
+		if pos.IsExpired(now) {
+			delete(v.index, key)
+			continue
+		}
+		if pos.Blob != nil {
+			if _, err := v.readRecord(pos); err != nil {
+				v.addProblem(filepath.Base(data.GetBlobFileName(v.dir, pos.Blob.Fid)), pos.Blob.Offset, err)
+				delete(v.index, key)
+			}
+		}
+	}
+	v.report.Keys = len(v.index)
+	return nil
+}
+
+// 获取目录中指定后缀的所有文件 id
+func (v *verifier) listFiles(suffix string) ([]uint32, error) {
+	dirEntries, err := os.ReadDir(v.dir)
+	if err != nil {
+		return nil, err
+	}
+	var fileIds []uint32
+	for _, entry := range dirEntries {
+		if !strings.HasSuffix(entry.Name(), suffix) {
+			continue
+		}
+		fileId, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), suffix))
+		if err != nil {
+			v.addProblem(entry.Name(), 0, ErrDataDirectoryCorrupted)
+			continue
+		}
+		fileIds = append(fileIds, uint32(fileId))
+	}
+	sort.Slice(fileIds, func(i, j int) bool {
+		return fileIds[i] < fileIds[j]
+	})
+	return fileIds, nil
+}
+
+// 遍历文件中的所有记录，读取失败或者文件末尾有多余的数据时记录问题，并停止读取这个文件
+func (v *verifier) walkFile(file *data.DataFile, name string,
+	fn func(logRecord *data.LogRecord, offset, size uint64)) error {
+	v.report.Files++
+
+	fileSize, err := file.IoManager.Size()
+	if err != nil {
+		return err
+	}
+
+	var offset uint64 = 0
+	for {
+		logRecord, size, err := file.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			v.addProblem(name, offset, err)
+			return nil
+		}
+		v.report.Records++
+		if fn != nil {
+			fn(logRecord, offset, size)
+		}
+		offset += size
+	}
+
+	if offset < fileSize {
+		v.addProblem(name, offset, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// 校验所有的数据文件，并按照和加载索引时相同的规则得到有效的数据
+func (v *verifier) verifyDataFiles(fileIds []uint32) error {
+	type txnRecord struct {
+		file   string
+		offset uint64
+		key    []byte
+		typ    data.LogRecordType
+		pos    *data.LogRecordPos
+	}
+	transactionRecords := make(map[uint64][]*txnRecord)
+
+	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+		if typ == data.LogRecordDeleted {
+			delete(v.index, string(key))
+		} else {
+			v.index[string(key)] = pos
+		}
+	}
+
+	for _, fid := range fileIds {
+		name := filepath.Base(data.GetDataFileName(v.dir, fid))
+		if err := v.walkFile(v.dataFiles[fid], name, func(logRecord *data.LogRecord, offset, size uint64) {
+			pos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: size, Expire: logRecord.Expire}
+			if logRecord.Type == data.LogRecordBlobPointer {
+				pos.Blob = data.DecodeLogRecordPos(logRecord.Value)
+			}
+
+			realKey, seqNum := parseLogRecordKey(logRecord.Key)
+			if seqNum == nonTransactionSeqNum && logRecord.Type == data.LogRecordRangeDeleted {
+				for key := range v.index {
+					if inRange([]byte(key), realKey, logRecord.Value) {
+						delete(v.index, key)
+					}
+				}
+				return
+			}
+			if seqNum == nonTransactionSeqNum {
+				updateIndex(realKey, logRecord.Type, pos)
+				return
+			}
+			if logRecord.Type == data.LogRecordTxnFinished {
+				for _, record := range transactionRecords[seqNum] {
+					updateIndex(record.key, record.typ, record.pos)
+				}
+				delete(transactionRecords, seqNum)
+				return
+			}
+			transactionRecords[seqNum] = append(transactionRecords[seqNum], &txnRecord{
+				file: name, offset: offset, key: realKey, typ: logRecord.Type, pos: pos,
+			})
+		}); err != nil {
+			return err
+		}
+	}
+
+	// 没有事务完成标识的事务记录
+	var orphans []*txnRecord
+	for _, records := range transactionRecords {
+		orphans = append(orphans, records...)
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		if orphans[i].pos.Fid != orphans[j].pos.Fid {
+			return orphans[i].pos.Fid < orphans[j].pos.Fid
+		}
+		return orphans[i].offset < orphans[j].offset
+	})
+	for _, record := range orphans {
+		v.addProblem(record.file, record.offset, ErrOrphanedTxnRecord)
+	}
+	return nil
+}
+
+// 校验 hint 文件中的每一条索引，withSeq 表示索引中的 key 是否带有事务序列号，skipFiles 中的文件不校验
+func (v *verifier) verifyHintFile(name string, open func(dirPath string) (*data.DataFile, error), withSeq bool, skipFiles map[uint32]bool) error {
+	if _, err := os.Stat(filepath.Join(v.dir, name)); os.IsNotExist(err) {
+		return nil
+	}
+	hintFile, err := open(v.dir)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	hintFile.Codec = v.codec
+
+	return v.walkFile(hintFile, name, func(logRecord *data.LogRecord, offset, size uint64) {
+		pos := data.DecodeLogRecordPos(logRecord.Value)
+		if skipFiles[pos.Fid] {
+			return
+		}
+		dataFile := v.dataFiles[pos.Fid]
+		if dataFile == nil {
+			v.addProblem(name, offset, ErrInvalidHintEntry)
+			return
+		}
+		record, recordSize, err := dataFile.ReadLogRecord(pos.Offset)
+		if err != nil || recordSize != pos.Size {
+			v.addProblem(name, offset, ErrInvalidHintEntry)
+			return
+		}
+		key := record.Key
+		if !withSeq {
+			key, _ = parseLogRecordKey(record.Key)
+		}
+		if !bytes.Equal(key, logRecord.Key) {
+			v.addProblem(name, offset, ErrInvalidHintEntry)
+		}
+	})
+}
+
+// 校验 seq-num、merge-finished 和 compact-finished 文件，第一条记录的 key 必须是 expectKey，value 必须是数字
+func (v *verifier) verifyMetaFile(name, expectKey string, open func(dirPath string) (*data.DataFile, error)) error {
+	if _, err := os.Stat(filepath.Join(v.dir, name)); os.IsNotExist(err) {
+		return nil
+	}
+	file, err := open(v.dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	file.Codec = v.codec
+
+	return v.walkFile(file, name, func(logRecord *data.LogRecord, offset, size uint64) {
+		if offset > 0 {
+			return
+		}
+		if string(logRecord.Key) != expectKey {
+			v.addProblem(name, offset, ErrDataDirectoryCorrupted)
+			return
+		}
+		if _, err := strconv.ParseUint(string(logRecord.Value), 10, 64); err != nil {
+			v.addProblem(name, offset, ErrDataDirectoryCorrupted)
+		}
+	})
+}
+
+// 读取有效数据对应的记录，指向 blob 文件的数据读取 blob 中的 value
+func (v *verifier) readRecord(pos *data.LogRecordPos) (*data.LogRecord, error) {
+	file, offset := v.dataFiles[pos.Fid], pos.Offset
+	if pos.Blob != nil {
+		file, offset = v.blobFiles[pos.Blob.Fid], pos.Blob.Offset
+	}
+	if file == nil {
+		return nil, ErrDataFileNotFound
+	}
+	logRecord, _, err := file.ReadLogRecord(offset)
+	return logRecord, err
+}
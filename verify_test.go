@@ -0,0 +1,206 @@
+package bitcask_go
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+)
+
+func TestVerify(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 1000; i++ {
+		err := db.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = db.Merge()
+	assert.Nil(t, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 1000; i < 2000; i++ {
+		err := wb.Put(utils.GetTestKey(i), utils.GetTestKey(i))
+		assert.Nil(t, err)
+	}
+	err = wb.Commmit()
+	assert.Nil(t, err)
+	activeFid := db.activeFile.FileId
+	err = db.Close()
+	assert.Nil(t, err)
+
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 2000, report.Keys)
+
+	// 数据库打开时不能校验
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	_, err = Verify(dir)
+	assert.Equal(t, ErrDatabaseIsUsing, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// 没有事务完成标识的事务记录，以及末尾不完整的记录
+	orphan, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeq([]byte("orphan"), 999), Value: []byte("value")})
+	appendToFile(t, data.GetDataFileName(dir, activeFid), orphan)
+	appendToFile(t, data.GetDataFileName(dir, activeFid), orphan[:len(orphan)/2])
+
+	// 指向无效位置的 hint 索引
+	hintFile, err := data.OpenHintFile(dir, fio.StandardFIO)
+	assert.Nil(t, err)
+	err = hintFile.WriteHintRecord([]byte("bogus"), &data.LogRecordPos{Fid: 0, Offset: 1, Size: 10})
+	assert.Nil(t, err)
+	_ = hintFile.Close()
+
+	// 旧的数据文件中校验失败的记录
+	f, err := os.OpenFile(data.GetDataFileName(dir, activeFid-1), os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.WriteAt([]byte("x"), 20)
+	assert.Nil(t, err)
+	_ = f.Close()
+
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	problems := make(map[error][]string)
+	for _, problem := range report.Problems {
+		problems[problem.Err] = append(problems[problem.Err], problem.File)
+	}
+	activeName := filepath.Base(data.GetDataFileName(dir, activeFid))
+	assert.Equal(t, []string{activeName}, problems[ErrOrphanedTxnRecord])
+	assert.Equal(t, []string{activeName}, problems[io.ErrUnexpectedEOF])
+	assert.Equal(t, []string{filepath.Base(data.GetDataFileName(dir, activeFid-1))}, problems[data.ErrInvalidCRC])
+	assert.Contains(t, problems[ErrInvalidHintEntry], data.HintFileName)
+	assert.True(t, report.Keys < 2000)
+
+	// 修复之后的目录中只有有效的数据
+	targetDir, _ := os.MkdirTemp("", "bitcask-go-verify-repair")
+	defer os.RemoveAll(targetDir)
+	repairOpts := DefaultOptions
+	repairOpts.DirPath = targetDir
+	report, err = Repair(dir, repairOpts)
+	assert.Nil(t, err)
+
+	repaired, err := Verify(targetDir)
+	assert.Nil(t, err)
+	assert.True(t, repaired.OK())
+	assert.Equal(t, report.Keys, repaired.Keys)
+
+	opts.DirPath = targetDir
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, report.Keys, len(db.ListKeys()))
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	_, err = Repair(dir, repairOpts)
+	assert.Equal(t, ErrRepairDirNotEmpty, err)
+}
+
+// 修复之后的目录保留原来的索引类型、压缩、blob 和加密配置
+func TestRepair_Options(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-options")
+	opts.DirPath = dir
+	opts.IndexType = ART
+	opts.Compression = GzipCompression
+	opts.BlobThreshold = 256
+	opts.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(512)))
+	}
+	last, err := db.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// 缺少密钥时无法读取
+	targetDir, _ := os.MkdirTemp("", "bitcask-go-repair-options-target")
+	defer os.RemoveAll(targetDir)
+	repairOpts := opts
+	repairOpts.DirPath = targetDir
+	repairOpts.EncryptionKey = nil
+	report, err := Repair(dir, repairOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Keys)
+	assert.Nil(t, os.RemoveAll(targetDir))
+
+	repairOpts.EncryptionKey = opts.EncryptionKey
+	report, err = Repair(dir, repairOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, report.Keys)
+
+	m, err := readManifest(targetDir)
+	assert.Nil(t, err)
+	assert.Equal(t, ART, m.IndexType)
+	assert.True(t, m.Encrypted)
+
+	db2, err := Open(repairOpts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1), db2.Stat().BlobFileNum)
+	val, err := db2.Get(utils.GetTestKey(99))
+	assert.Nil(t, err)
+	assert.Equal(t, last, val)
+	assert.Nil(t, db2.Close())
+}
+
+// 校验不会在数据目录中创建文件
+func TestVerify_NoWrite(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-no-write")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+
+	names := func() []string {
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// 锁文件存在时获取共享锁
+	before := names()
+	report, err := Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, before, names())
+
+	// 锁文件不存在时不加锁，也不创建
+	assert.Nil(t, os.Remove(filepath.Join(dir, fileLockName)))
+	before = names()
+	report, err = Verify(dir)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, before, names())
+}
@@ -0,0 +1,344 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+
+	"bitcask-go/data"
+)
+
+type EventType = byte
+
+const (
+	// EventPut 写入 key
+	EventPut EventType = iota
+
+	// EventDelete 删除 key
+	EventDelete
+
+	// EventDeleteRange 删除 [Key, Value) 区间中的所有 key，Value 为空表示没有上界
+	EventDeleteRange
+)
+
+// Change 一个 key 的变更
+type Change struct {
+	Type  EventType
+	Key   []byte
+	Value []byte // 删除时为空，范围删除时是区间的结束位置
+}
+
+// Event 一次提交产生的变更，WriteBatch 和事务中的所有变更在同一个 Event 中，按照写入数据文件的顺序排列
+// Err 不为空时订阅已经结束，这是 channel 关闭之前的最后一个 Event
+type Event struct {
+	Seq     uint64 // 提交在数据文件中结束的位置，高 32 位是文件 id，低 32 位是偏移，按照提交的顺序递增
+	Changes []Change
+	Err     error
+}
+
+// 每个订阅者最多缓存的 Event 数量
+const maxWatchQueueSize = 4096
+
+// 订阅者，提交时把变更放入队列，由单独的协程按照顺序发送到 channel 中，不会阻塞写入
+// 队列满了之后不再接收新的变更，发送完已经缓存的 Event 之后以 ErrWatchQueueFull 结束订阅
+type watcher struct {
+	prefix []byte
+	ch     chan Event
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []Event
+	full   bool
+	closed bool
+}
+
+func newWatcher(prefix []byte) *watcher {
+	w := &watcher{
+		prefix: prefix,
+		ch:     make(chan Event),
+		done:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// 由数据文件中的位置得到 Event 的序列号
+func eventSeq(fid uint32, offset uint64) uint64 {
+	return uint64(fid)<<32 | offset
+}
+
+// Watch 订阅 key 以 prefix 开头的变更，按照提交的顺序发送到返回的 channel 中，prefix 为空表示订阅所有的 key
+// 调用 cancel 取消订阅之后 channel 会被关闭，来不及读取的变更缓存在内存中，
+// 缓存的 Event 超过 maxWatchQueueSize 时订阅以 ErrWatchQueueFull 结束，可以使用最后收到的 Event.Seq 调用 WatchFrom 继续订阅
+func (db *DB) Watch(prefix []byte) (<-chan Event, func()) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	w := db.addWatcher(prefix)
+	go w.run(nil)
+	return w.ch, func() { db.removeWatcher(w) }
+}
+
+// WatchFrom 先重放数据文件中 seq 之后提交的变更，再继续订阅新的变更
+// 重启之后使用最后收到的 Event.Seq 继续订阅，seq 所在的文件已经被 Merge 或者 MergeFiles 重写时返回 ErrWatchSeqCompacted
+// 数据库为空时 seq 只能为 0，直接订阅新的变更
+// 重放时读取出错，或者 value 所在的 blob 文件已经被 BlobGC 回收（返回 ErrWatchSeqCompacted），订阅以带有 Err 的 Event 结束
+func (db *DB) WatchFrom(prefix []byte, seq uint64) (<-chan Event, func(), error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	fid, offset := uint32(seq>>32), seq&(1<<32-1)
+	if fid < db.nonMergeFileId || fid < db.compactedFileId {
+		return nil, nil, ErrWatchSeqCompacted
+	}
+	if db.activeFile == nil {
+		if seq != 0 {
+			return nil, nil, ErrInvalidWatchSeq
+		}
+		w := db.addWatcher(prefix)
+		go w.run(nil)
+		return w.ch, func() { db.removeWatcher(w) }, nil
+	}
+	if fid > db.activeFile.FileId ||
+		(fid == db.activeFile.FileId && offset > db.activeFile.WriteOff) {
+		return nil, nil, ErrInvalidWatchSeq
+	}
+	if _, ok := db.olderFiles[fid]; !ok && fid != db.activeFile.FileId {
+		return nil, nil, ErrInvalidWatchSeq
+	}
+
+	// 持有读锁，重放到当前活跃文件的 WriteOff 为止，之后提交的变更进入队列
+	view := db.newFileView()
+	end := eventSeq(db.activeFile.FileId, db.activeFile.WriteOff)
+	w := db.addWatcher(prefix)
+	go w.run(func() error {
+		defer db.releaseFileView(view)
+		return db.replayEvents(view, w, seq, end)
+	})
+	return w.ch, func() { db.removeWatcher(w) }, nil
+}
+
+// 在访问此方法前必须持有互斥锁（读锁即可）
+func (db *DB) addWatcher(prefix []byte) *watcher {
+	w := newWatcher(append([]byte(nil), prefix...))
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	db.watchers[w] = struct{}{}
+	return w
+}
+
+func (db *DB) removeWatcher(w *watcher) {
+	db.watchMu.Lock()
+	delete(db.watchers, w)
+	db.watchMu.Unlock()
+	w.close()
+}
+
+// 关闭所有的订阅
+func (db *DB) closeWatchers() {
+	db.watchMu.Lock()
+	watchers := db.watchers
+	db.watchers = make(map[*watcher]struct{})
+	db.watchMu.Unlock()
+	for w := range watchers {
+		w.close()
+	}
+}
+
+// 通知订阅者提交的变更，必须在提交的数据更新到内存索引之后调用
+// 在访问此方法前必须持有互斥锁
+func (db *DB) notifyWatchers(seq uint64, changes []Change) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	if len(db.watchers) == 0 {
+		return
+	}
+
+	// 用户传入的 key 和 value 在写入之后可能被修改，拷贝一份
+	copied := make([]Change, len(changes))
+	for i, change := range changes {
+		copied[i] = Change{
+			Type:  change.Type,
+			Key:   append([]byte(nil), change.Key...),
+			Value: append([]byte(nil), change.Value...),
+		}
+	}
+	for w := range db.watchers {
+		w.push(Event{Seq: seq, Changes: copied})
+	}
+}
+
+// 只保留 key 以 prefix 开头的变更，以及和 prefix 有交集的范围删除
+func (w *watcher) filter(event Event) (Event, bool) {
+	if len(w.prefix) == 0 {
+		return event, true
+	}
+	var changes []Change
+	for _, change := range event.Changes {
+		if change.Type == EventDeleteRange && rangeOverlapsPrefix(change.Key, change.Value, w.prefix) ||
+			change.Type != EventDeleteRange && bytes.HasPrefix(change.Key, w.prefix) {
+			changes = append(changes, change)
+		}
+	}
+	return Event{Seq: event.Seq, Changes: changes}, len(changes) > 0
+}
+
+func (w *watcher) push(event Event) {
+	event, ok := w.filter(event)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.full {
+		return
+	}
+	if len(w.queue) >= maxWatchQueueSize {
+		w.full = true
+	} else {
+		w.queue = append(w.queue, event)
+	}
+	w.cond.Signal()
+}
+
+func (w *watcher) close() {
+	w.once.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.cond.Signal()
+		w.mu.Unlock()
+		close(w.done)
+	})
+}
+
+// [start, end) 区间中是否可能有以 prefix 开头的 key
+func rangeOverlapsPrefix(start, end, prefix []byte) bool {
+	if len(end) > 0 && bytes.Compare(end, prefix) <= 0 {
+		return false
+	}
+	prefixEnd := prefixEnd(prefix)
+	return prefixEnd == nil || bytes.Compare(start, prefixEnd) < 0
+}
+
+// 发送到 channel，取消订阅时返回 false
+func (w *watcher) send(event Event) bool {
+	select {
+	case w.ch <- event:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// 先执行重放，然后按照顺序发送队列中的变更，直到取消订阅
+// 重放出错或者队列满了时发送带有 Err 的 Event 之后结束
+func (w *watcher) run(replay func() error) {
+	defer close(w.ch)
+	if replay != nil {
+		if err := replay(); err != nil {
+			if err != errWatcherClosed {
+				w.send(Event{Err: err})
+			}
+			return
+		}
+	}
+
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && !w.full && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.mu.Unlock()
+			return
+		}
+		// 逐个取出，队列中的 Event 发送之后才让出位置
+		if len(w.queue) == 0 {
+			// 队列满了之后 push 不再写入，发送完已经缓存的 Event 之后结束
+			w.mu.Unlock()
+			w.send(Event{Err: ErrWatchQueueFull})
+			return
+		}
+		event := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mu.Unlock()
+
+		if !w.send(event) {
+			return
+		}
+	}
+}
+
+// 重放时取消了订阅
+var errWatcherClosed = errors.New("watcher closed")
+
+// 按照顺序读取 fileView 中从 from 到 end 之间的记录，事务中的记录在读到事务完成的标识之后一起发送
+// 取消订阅时返回 errWatcherClosed
+func (db *DB) replayEvents(view *fileView, w *watcher, from, end uint64) error {
+	var fids []uint32
+	for fid := range view.files {
+		if fid >= uint32(from>>32) && fid <= uint32(end>>32) {
+			fids = append(fids, fid)
+		}
+	}
+	sort.Slice(fids, func(i, j int) bool { return fids[i] < fids[j] })
+
+	pendingTxns := make(map[uint64][]Change)
+	for _, fid := range fids {
+		dataFile := view.files[fid]
+		var offset uint64
+		if fid == uint32(from>>32) {
+			offset = from & (1<<32 - 1)
+		}
+		for eventSeq(fid, offset) < end {
+			logRecord, size, err := dataFile.ReadLogRecord(offset)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			offset += size
+
+			realKey, seqNum := parseLogRecordKey(logRecord.Key)
+			change := Change{Type: EventPut, Key: realKey, Value: logRecord.Value}
+			switch logRecord.Type {
+			case data.LogRecordDeleted:
+				change = Change{Type: EventDelete, Key: realKey}
+			case data.LogRecordRangeDeleted:
+				change = Change{Type: EventDeleteRange, Key: realKey, Value: logRecord.Value}
+			case data.LogRecordBlobPointer:
+				// blob 文件已经被 BlobGC 回收，无法得到这次写入的 value
+				blobPos := data.DecodeLogRecordPos(logRecord.Value)
+				blobFile, ok := view.blobs[blobPos.Fid]
+				if !ok {
+					return ErrWatchSeqCompacted
+				}
+				value, err := readValueFromDataFile(blobFile, blobPos)
+				if err != nil {
+					return err
+				}
+				change.Value = value
+			case data.LogRecordTxnFinished:
+				changes := pendingTxns[seqNum]
+				delete(pendingTxns, seqNum)
+				if event, ok := w.filter(Event{Seq: eventSeq(fid, offset), Changes: changes}); ok && !w.send(event) {
+					return errWatcherClosed
+				}
+				continue
+			}
+
+			if seqNum != nonTransactionSeqNum {
+				pendingTxns[seqNum] = append(pendingTxns[seqNum], change)
+				continue
+			}
+			if event, ok := w.filter(Event{Seq: eventSeq(fid, offset), Changes: []Change{change}}); ok && !w.send(event) {
+				return errWatcherClosed
+			}
+		}
+	}
+	return nil
+}
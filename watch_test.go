@@ -0,0 +1,225 @@
+package bitcask_go
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"bitcask-go/utils"
+)
+
+func receiveEvent(t *testing.T, ch <-chan Event) Event {
+	select {
+	case event, ok := <-ch:
+		assert.True(t, ok)
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	all, cancelAll := db.Watch(nil)
+	users, cancelUsers := db.Watch([]byte("user:"))
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("order:1"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	// 删除不存在的 key 不产生变更
+	assert.Nil(t, db.Delete([]byte("not exist")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("order:2"), []byte("d")))
+	assert.Nil(t, wb.Commmit())
+
+	e1 := receiveEvent(t, all)
+	assert.Equal(t, []Change{{Type: EventPut, Key: []byte("user:1"), Value: []byte("a")}}, e1.Changes)
+	e2 := receiveEvent(t, all)
+	assert.Equal(t, []byte("order:1"), e2.Changes[0].Key)
+	e3 := receiveEvent(t, all)
+	assert.Equal(t, []Change{{Type: EventDelete, Key: []byte("user:1")}}, e3.Changes)
+	// 批量写入的变更在同一个 Event 中
+	e4 := receiveEvent(t, all)
+	assert.Equal(t, 2, len(e4.Changes))
+	assert.True(t, e1.Seq < e2.Seq && e2.Seq < e3.Seq && e3.Seq < e4.Seq)
+
+	// 只收到指定前缀的变更
+	assert.Equal(t, e1, receiveEvent(t, users))
+	assert.Equal(t, e3.Seq, receiveEvent(t, users).Seq)
+	e := receiveEvent(t, users)
+	assert.Equal(t, e4.Seq, e.Seq)
+	assert.Equal(t, []Change{{Type: EventPut, Key: []byte("user:2"), Value: []byte("c")}}, e.Changes)
+
+	// 取消订阅之后 channel 被关闭
+	cancelUsers()
+	_, ok := <-users
+	assert.False(t, ok)
+	cancelAll()
+	_, ok = <-all
+	assert.False(t, ok)
+}
+
+func TestDB_WatchFrom(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-from")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ch, cancel := db.Watch(nil)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	var events []Event
+	for i := 0; i < 1000; i++ {
+		events = append(events, receiveEvent(t, ch))
+	}
+	cancel()
+	assert.True(t, db.activeFile.FileId > 0)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch1"), []byte("value")))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Commmit())
+	assert.Nil(t, db.Close())
+
+	// 重启之后从第 100 个变更之后继续，跨越多个数据文件
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	db = db2
+	ch, cancel, err = db2.WatchFrom(nil, events[99].Seq)
+	assert.Nil(t, err)
+	for i := 100; i < 1000; i++ {
+		assert.Equal(t, events[i], receiveEvent(t, ch))
+	}
+	e := receiveEvent(t, ch)
+	assert.Equal(t, 2, len(e.Changes))
+
+	// 重放完成之后继续收到新的变更
+	assert.Nil(t, db2.Put([]byte("after"), []byte("value")))
+	e = receiveEvent(t, ch)
+	assert.Equal(t, []byte("after"), e.Changes[0].Key)
+	cancel()
+
+	// 无效的序列号
+	_, _, err = db2.WatchFrom(nil, eventSeq(db2.activeFile.FileId+1, 0))
+	assert.Equal(t, ErrInvalidWatchSeq, err)
+
+	// merge 之后之前的数据文件被重写
+	assert.Nil(t, db2.Merge())
+	_, _, err = db2.WatchFrom(nil, events[99].Seq)
+	assert.Equal(t, ErrWatchSeqCompacted, err)
+}
+
+// 批量写入的变更按照写入数据文件的顺序发送，和重放时的顺序一致
+func TestDB_Watch_BatchOrder(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-batch-order")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// 数据库为空时可以从 0 开始订阅
+	ch, cancel, err := db.WatchFrom(nil, 0)
+	assert.Nil(t, err)
+	_, _, err = db.WatchFrom(nil, eventSeq(0, 1))
+	assert.Equal(t, ErrInvalidWatchSeq, err)
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, wb.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, wb.Commmit())
+	event := receiveEvent(t, ch)
+	assert.Equal(t, 100, len(event.Changes))
+	cancel()
+
+	ch, cancel, err = db.WatchFrom(nil, 0)
+	assert.Nil(t, err)
+	defer cancel()
+	assert.Equal(t, event, receiveEvent(t, ch))
+}
+
+// 订阅者来不及读取时最多缓存 maxWatchQueueSize 个 Event，之后以 ErrWatchQueueFull 结束
+func TestDB_Watch_QueueFull(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-queue-full")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ch, cancel := db.Watch(nil)
+	defer cancel()
+	for i := 0; i < maxWatchQueueSize*2; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+
+	var events []Event
+	for event := range ch {
+		events = append(events, event)
+	}
+	assert.True(t, len(events) <= maxWatchQueueSize+2)
+	last := events[len(events)-1]
+	assert.Equal(t, ErrWatchQueueFull, last.Err)
+	for i, event := range events[:len(events)-1] {
+		assert.Nil(t, event.Err)
+		assert.Equal(t, utils.GetTestKey(i), event.Changes[0].Key)
+	}
+
+	// 使用最后收到的 Seq 继续订阅
+	ch, cancel2, err := db.WatchFrom(nil, events[len(events)-2].Seq)
+	assert.Nil(t, err)
+	defer cancel2()
+	assert.Equal(t, utils.GetTestKey(len(events)-1), receiveEvent(t, ch).Changes[0].Key)
+}
+
+// 重放时 value 所在的 blob 文件已经被回收，以 ErrWatchSeqCompacted 结束
+func TestDB_WatchFrom_BlobCollected(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-blob-gc")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.BlobThreshold = 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), bytes.Repeat([]byte("v"), 4096)))
+	}
+	for i := 0; i < 20; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), []byte("small")))
+	}
+	blobFileNum := db.Stat().BlobFileNum
+	assert.Nil(t, db.BlobGC())
+	assert.True(t, db.Stat().BlobFileNum < blobFileNum)
+
+	ch, cancel, err := db.WatchFrom(nil, 0)
+	assert.Nil(t, err)
+	defer cancel()
+	event := receiveEvent(t, ch)
+	assert.Equal(t, ErrWatchSeqCompacted, event.Err)
+	_, ok := <-ch
+	assert.False(t, ok)
+}